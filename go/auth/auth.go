@@ -0,0 +1,188 @@
+// Package auth contains the messages and helpers used by identities to
+// authenticate the requests they send to issuers and other services, by
+// signing them with their operational babyjub key.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/core/claims"
+	"github.com/iden3/go-iden3-core/db"
+	"github.com/iden3/go-iden3-core/keystore"
+	"github.com/iden3/go-iden3-core/merkletree"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	issuerMsg "github.com/iden3/go-iden3-servers-demo/servers/issuerdemo/messages"
+)
+
+const (
+	// DomainReservedPrefix is the prefix of the domains used internally by the
+	// library. Applications can't sign messages with domains that start with it.
+	DomainReservedPrefix = "iden3."
+	// DomainClaimRequest is the domain of the signatures of claim requests sent to issuers.
+	DomainClaimRequest = DomainReservedPrefix + "issuer.claimrequest"
+	// DomainStateTransition is the domain of the signatures of the state transitions sent to relayers.
+	DomainStateTransition = DomainReservedPrefix + "relayer.statetransition"
+	// DomainPublicData is the domain of the signatures of the off chain public data sent to relayers.
+	DomainPublicData = DomainReservedPrefix + "relayer.publicdata"
+	nonceLen         = 16
+)
+
+var (
+	ErrKeyProofNonExistence = errors.New("The key proof is of non-existence")
+	ErrKeyProofRoot         = errors.New("The key proof doesn't match the genesis claims tree root")
+	ErrKeyProofId           = errors.New("The genesis claims tree root doesn't match the identity")
+	ErrSignatureExpired     = errors.New("The signature timestamp is outside of the accepted window")
+	ErrSignatureInvalid     = errors.New("Invalid signature")
+	ErrDomainMismatch       = errors.New("The message was signed for a different domain")
+)
+
+// KeyProof proves that KOp is the operational key of a genesis identity: the
+// claim that authorizes KOp is in the genesis claims tree with root
+// ClaimsTreeRoot, from which the identity ID is derived.
+type KeyProof struct {
+	KOp            *babyjub.PublicKeyComp `json:"kOp" validate:"required"`
+	ClaimsTreeRoot *merkletree.Hash       `json:"claimsTreeRoot" validate:"required"`
+	Mtp            *merkletree.Proof      `json:"mtp" validate:"required"`
+}
+
+// Verify checks that the KeyProof is valid for the identity id.
+func (kp *KeyProof) Verify(id *core.ID) error {
+	kOp, err := kp.KOp.Decompress()
+	if err != nil {
+		return err
+	}
+	if !kp.Mtp.Existence {
+		return ErrKeyProofNonExistence
+	}
+	// The claim that authorizes the genesis kOp is always the first one
+	// issued by the identity, so its revocation nonce is 0.
+	claimKOp := claims.NewClaimKeyBabyJub(kOp, claims.BabyJubKeyTypeAuthorizeKSign)
+	hi, hv, err := claimKOp.Entry().HiHv()
+	if err != nil {
+		return err
+	}
+	root, err := merkletree.RootFromProof(kp.Mtp, hi, hv)
+	if err != nil {
+		return err
+	}
+	if !root.Equals(kp.ClaimsTreeRoot) {
+		return ErrKeyProofRoot
+	}
+	// Calculate the genesis ID from the genesis claims tree root
+	rootsTree, err := merkletree.NewMerkleTree(db.NewMemoryStorage(), 140)
+	if err != nil {
+		return err
+	}
+	if err := claims.AddLeafRootsTree(rootsTree, kp.ClaimsTreeRoot); err != nil {
+		return err
+	}
+	idGenesis := core.IdGenesisFromIdenState(core.IdenState(kp.ClaimsTreeRoot, &merkletree.HashZero, rootsTree.RootKey()))
+	if !idGenesis.Equal(id) {
+		return ErrKeyProofId
+	}
+	return nil
+}
+
+// Signature authenticates a payload sent by an identity. It includes the proof
+// that the signing key belongs to the identity.
+type Signature struct {
+	KeyProof  KeyProof               `json:"keyProof" validate:"required"`
+	Timestamp int64                  `json:"timestamp" validate:"required"`
+	Nonce     string                 `json:"nonce" validate:"required"`
+	Signature *babyjub.SignatureComp `json:"signature" validate:"required"`
+}
+
+// NewNonce returns a random nonce to be used in a Signature.
+func NewNonce() (string, error) {
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// MessageToSign builds the message that is signed to authenticate payload:
+//
+//	domain | 0x00 | id (31 bytes) | timestamp (uint64 big endian) | nonce | 0x00 | sha256(payload)
+//
+// The domain separates the signatures used for different purposes, so a
+// signature can't be reused in a different context.
+func MessageToSign(domain string, id *core.ID, timestamp int64, nonce string, payload []byte) []byte {
+	payloadHash := sha256.Sum256(payload)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+	msg := make([]byte, 0, len(domain)+1+len(id)+len(ts)+len(nonce)+1+len(payloadHash))
+	msg = append(msg, []byte(domain)...)
+	msg = append(msg, 0)
+	msg = append(msg, id[:]...)
+	msg = append(msg, ts[:]...)
+	msg = append(msg, []byte(nonce)...)
+	msg = append(msg, 0)
+	msg = append(msg, payloadHash[:]...)
+	return msg
+}
+
+// Verify checks that the signature of payload in domain was done by the
+// identity id, and that the signature timestamp is not further than maxAge
+// from now.
+func (s *Signature) Verify(domain string, id *core.ID, payload []byte, now time.Time, maxAge time.Duration) error {
+	ts := time.Unix(s.Timestamp, 0)
+	if ts.Before(now.Add(-maxAge)) || ts.After(now.Add(maxAge)) {
+		return ErrSignatureExpired
+	}
+	if err := s.KeyProof.Verify(id); err != nil {
+		return err
+	}
+	ok, err := keystore.VerifySignatureRaw(s.KeyProof.KOp, s.Signature,
+		MessageToSign(domain, id, s.Timestamp, s.Nonce, payload))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// ReqClaimRequest is a claim request authenticated by the requesting identity.
+type ReqClaimRequest struct {
+	issuerMsg.ReqClaimRequest
+	Signature *Signature `json:"signature" validate:"required"`
+}
+
+// ClaimRequestPayload returns the payload of a claim request that is signed.
+func ClaimRequestPayload(req *issuerMsg.ReqClaimRequest) []byte {
+	return []byte(fmt.Sprintf("%s\x00%s", req.Index, req.Value))
+}
+
+// SignedMessage is an arbitrary message signed by an identity, used to
+// authenticate the identity in front of a service (for example, to log in).
+// The signed payload is the message as is, and the service chooses the domain,
+// so that a message signed for a service is not valid for another one.
+type SignedMessage struct {
+	ID        *core.ID  `json:"id" validate:"required"`
+	Domain    string    `json:"domain" validate:"required"`
+	Message   string    `json:"message"`
+	Signature Signature `json:"signature" validate:"required"`
+}
+
+// Verify checks that the message has been signed in the expected domain by
+// the identity, and that the signature timestamp is not further than maxAge
+// from now.
+func (sm *SignedMessage) Verify(domain string, now time.Time, maxAge time.Duration) error {
+	if sm.Domain != domain {
+		return ErrDomainMismatch
+	}
+	if sm.ID == nil || sm.Signature.KeyProof.KOp == nil || sm.Signature.KeyProof.ClaimsTreeRoot == nil ||
+		sm.Signature.KeyProof.Mtp == nil || sm.Signature.Signature == nil {
+		return errors.New("Incomplete signed message")
+	}
+	return sm.Signature.Verify(domain, sm.ID, []byte(sm.Message), now, maxAge)
+}
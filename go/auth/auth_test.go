@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageToSign(t *testing.T) {
+	id := core.NewID(core.TypeBJP0, [27]byte{1, 2, 3})
+	msg := MessageToSign("domain", &id, 1, "nonce", []byte("payload"))
+	require.Equal(t, len("domain")+1+len(id)+8+len("nonce")+1+32, len(msg))
+	require.Equal(t, msg, MessageToSign("domain", &id, 1, "nonce", []byte("payload")))
+	require.NotEqual(t, msg, MessageToSign("domain2", &id, 1, "nonce", []byte("payload")))
+	require.NotEqual(t, msg, MessageToSign("domain", &id, 2, "nonce", []byte("payload")))
+	require.NotEqual(t, msg, MessageToSign("domain", &id, 1, "nonce2", []byte("payload")))
+	require.NotEqual(t, msg, MessageToSign("domain", &id, 1, "nonce", []byte("payload2")))
+
+	nonce1, err := NewNonce()
+	require.Nil(t, err)
+	nonce2, err := NewNonce()
+	require.Nil(t, err)
+	require.Equal(t, 2*nonceLen, len(nonce1))
+	require.NotEqual(t, nonce1, nonce2)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	zktypes "github.com/iden3/go-circom-prover-verifier/types"
+	idenpubonchainlocal "github.com/iden3/go-iden3-core/components/idenpubonchain/local"
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/db"
+	"github.com/iden3/go-iden3-core/merkletree"
+)
+
+// chainClock is the time and block number of the local smart contract. The
+// time is the current time unless it's fixed to replay the state transitions.
+type chainClock struct {
+	rw        sync.RWMutex
+	mine      sync.Mutex
+	block     uint64
+	fixedTime int64
+}
+
+func (cc *chainClock) AddBlock() {
+	cc.mine.Lock()
+	defer cc.mine.Unlock()
+	cc.rw.Lock()
+	defer cc.rw.Unlock()
+	cc.block++
+}
+
+func (cc *chainClock) Block() uint64 {
+	cc.rw.RLock()
+	defer cc.rw.RUnlock()
+	return cc.block
+}
+
+func (cc *chainClock) Time() time.Time {
+	cc.rw.RLock()
+	defer cc.rw.RUnlock()
+	if cc.fixedTime != 0 {
+		return time.Unix(cc.fixedTime, 0)
+	}
+	return time.Now()
+}
+
+// fix sets the block and time of the clock, or releases the time if ts is 0.
+func (cc *chainClock) fix(block uint64, ts int64) {
+	cc.rw.Lock()
+	defer cc.rw.Unlock()
+	cc.block = block
+	cc.fixedTime = ts
+}
+
+// frozen calls fn with the clock stopped at the current block and time, so
+// that they are the same ones that the local smart contract records.
+func (cc *chainClock) frozen(fn func(block uint64, ts int64) error) error {
+	cc.mine.Lock()
+	defer cc.mine.Unlock()
+	block, ts := cc.Block(), time.Now().Unix()
+	cc.fix(block, ts)
+	defer cc.fix(block, 0)
+	return fn(block, ts)
+}
+
+// miner adds a block to the local smart contract every period until it's
+// stopped.
+type miner struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startMiner starts mining a block of idenPubOnChain every period.
+func startMiner(clock *chainClock, idenPubOnChain *idenpubonchainlocal.IdenPubOnChain,
+	period time.Duration) *miner {
+	m := &miner{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(m.done)
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-time.After(period):
+			}
+			clock.AddBlock()
+			idenPubOnChain.Sync()
+		}
+	}()
+	return m
+}
+
+// Stop stops mining and waits for the block being mined, if any.
+func (m *miner) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// stateTransition is a state transition accepted by the local smart contract.
+// GenesisState is only set in the first state transition of an identity.
+type stateTransition struct {
+	ID           *core.ID
+	GenesisState *merkletree.Hash
+	NewState     *merkletree.Hash
+	Proof        *zktypes.Proof
+	BlockN       uint64
+	BlockTs      int64
+}
+
+// journaledIdenPubOnChain is a local smart contract that keeps the accepted
+// state transitions in a storage, so that they can be replayed with the same
+// blocks and times when the server restarts.
+type journaledIdenPubOnChain struct {
+	*idenpubonchainlocal.IdenPubOnChain
+	clock   *chainClock
+	storage db.Storage
+	m       sync.Mutex
+	n       uint64
+}
+
+// newJournaledIdenPubOnChain replays the state transitions kept in storage.
+func newJournaledIdenPubOnChain(idenPubOnChain *idenpubonchainlocal.IdenPubOnChain, clock *chainClock,
+	storage db.Storage) (*journaledIdenPubOnChain, error) {
+	j := &journaledIdenPubOnChain{
+		IdenPubOnChain: idenPubOnChain,
+		clock:          clock,
+		storage:        storage,
+	}
+	var transitions []stateTransition
+	if err := storage.Iterate(func(key, value []byte) (bool, error) {
+		var st stateTransition
+		if err := json.Unmarshal(value, &st); err != nil {
+			return false, err
+		}
+		transitions = append(transitions, st)
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	// The keys are big endian counters, so the transitions are iterated in order
+	var lastBlock uint64
+	for _, st := range transitions {
+		clock.fix(st.BlockN, st.BlockTs)
+		var err error
+		if st.GenesisState != nil {
+			_, err = idenPubOnChain.InitState(st.ID, st.GenesisState, st.NewState, st.Proof)
+		} else {
+			_, err = idenPubOnChain.SetState(st.ID, st.NewState, st.Proof)
+		}
+		if err != nil {
+			return nil, err
+		}
+		idenPubOnChain.Sync()
+		lastBlock = st.BlockN
+	}
+	clock.fix(lastBlock, 0)
+	j.n = uint64(len(transitions))
+	return j, nil
+}
+
+func (j *journaledIdenPubOnChain) store(st stateTransition) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], j.n)
+	tx, err := j.storage.NewTx()
+	if err != nil {
+		return err
+	}
+	if err := db.StoreJSON(tx, key[:], st); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	j.n++
+	return nil
+}
+
+// InitState initializes the first state of the identity and keeps the state
+// transition in the journal.
+func (j *journaledIdenPubOnChain) InitState(id *core.ID, genesisState *merkletree.Hash,
+	newState *merkletree.Hash, proof *zktypes.Proof) (*types.Transaction, error) {
+	var tx *types.Transaction
+	if err := j.clock.frozen(func(blockN uint64, blockTs int64) error {
+		var err error
+		if tx, err = j.IdenPubOnChain.InitState(id, genesisState, newState, proof); err != nil {
+			return err
+		}
+		return j.store(stateTransition{
+			ID:           id,
+			GenesisState: genesisState,
+			NewState:     newState,
+			Proof:        proof,
+			BlockN:       blockN,
+			BlockTs:      blockTs,
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// SetState sets a new state of the identity and keeps the state transition in
+// the journal.
+func (j *journaledIdenPubOnChain) SetState(id *core.ID, newState *merkletree.Hash,
+	proof *zktypes.Proof) (*types.Transaction, error) {
+	var tx *types.Transaction
+	if err := j.clock.frozen(func(blockN uint64, blockTs int64) error {
+		var err error
+		if tx, err = j.IdenPubOnChain.SetState(id, newState, proof); err != nil {
+			return err
+		}
+		return j.store(stateTransition{
+			ID:       id,
+			NewState: newState,
+			Proof:    proof,
+			BlockN:   blockN,
+			BlockTs:  blockTs,
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	bn256 "github.com/ethereum/go-ethereum/crypto/bn256/cloudflare"
+	zktypes "github.com/iden3/go-circom-prover-verifier/types"
+	idenpubonchainlocal "github.com/iden3/go-iden3-core/components/idenpubonchain/local"
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/core/proof"
+	"github.com/iden3/go-iden3-core/db"
+	"github.com/iden3/go-iden3-core/merkletree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournaledIdenPubOnChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// With the points of the verification key and the proof at infinity (or
+	// the generator in G2) any state transition is valid
+	inf := new(bn256.G1).ScalarBaseMult(big.NewInt(0))
+	g2 := new(bn256.G2).ScalarBaseMult(big.NewInt(1))
+	vk := &zktypes.Vk{Alpha: inf, Beta: g2, Gamma: g2, Delta: g2, IC: []*bn256.G1{inf, inf, inf, inf}}
+	zkProof := &zktypes.Proof{A: inf, B: g2, C: inf}
+
+	// start starts the local smart contract replaying the state transitions
+	// stored in dir
+	start := func() (*idenpubonchainlocal.IdenPubOnChain, *journaledIdenPubOnChain, *chainClock, db.Storage) {
+		var clock chainClock
+		local := idenpubonchainlocal.New(clock.Time, clock.Block, vk)
+		storage, err := db.NewLevelDbStorage(path.Join(dir, "chain"), false)
+		require.Nil(t, err)
+		journaled, err := newJournaledIdenPubOnChain(local, &clock, storage)
+		require.Nil(t, err)
+		return local, journaled, &clock, storage
+	}
+	mine := func(local *idenpubonchainlocal.IdenPubOnChain, clock *chainClock) {
+		clock.AddBlock()
+		local.Sync()
+	}
+
+	local, journaled, clock, storage := start()
+	id := core.NewID(core.TypeBJP0, [27]byte{1})
+	states := []*merkletree.Hash{
+		merkletree.NewHashFromBigInt(big.NewInt(1)),
+		merkletree.NewHashFromBigInt(big.NewInt(2)),
+		merkletree.NewHashFromBigInt(big.NewInt(3)),
+	}
+	mine(local, clock)
+	_, err = journaled.InitState(&id, states[0], states[1], zkProof)
+	require.Nil(t, err)
+	mine(local, clock)
+	mine(local, clock)
+	_, err = journaled.SetState(&id, states[2], zkProof)
+	require.Nil(t, err)
+	mine(local, clock)
+	first, err := local.GetStateByBlock(&id, 1)
+	require.Nil(t, err)
+	last, err := local.GetState(&id)
+	require.Nil(t, err)
+	require.Equal(t, states[2], last.IdenState)
+	block := clock.Block()
+	storage.Close()
+
+	// The state transitions are replayed with the same blocks and times
+	local, journaled, clock, storage = start()
+	defer storage.Close()
+	var replayed *proof.IdenStateData
+	replayed, err = local.GetStateByBlock(&id, 1)
+	require.Nil(t, err)
+	require.Equal(t, first, replayed)
+	replayed, err = local.GetState(&id)
+	require.Nil(t, err)
+	require.Equal(t, last, replayed)
+	require.Equal(t, last.BlockN, clock.Block())
+	require.LessOrEqual(t, clock.Block(), block)
+
+	// New state transitions are appended to the journal
+	mine(local, clock)
+	_, err = journaled.SetState(&id, states[0], zkProof)
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), journaled.n)
+}
+
+func TestMiner(t *testing.T) {
+	var clock chainClock
+	local := idenpubonchainlocal.New(clock.Time, clock.Block, &zktypes.Vk{})
+	miner := startMiner(&clock, local, 10*time.Millisecond)
+	for clock.Block() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// No block is mined once the miner is stopped
+	miner.Stop()
+	block := clock.Block()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, block, clock.Block())
+}
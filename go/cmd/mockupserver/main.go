@@ -0,0 +1,172 @@
+// Command mockupserver runs the mockup issuer, verifier and relayer outside of
+// the Go tests, so that they can be used by the Android instrumented tests.
+// The state of the identities is published in a local (in memory) stand-in of
+// the smart contract, where a new block is mined every blockPeriod. If
+// storePath is set, the state of the server and the state transitions accepted
+// by the local smart contract are stored there, so that they survive restarts.
+//
+// Usage:
+//
+//	mockupserver -config mockupserver.yaml
+//
+// See mockupserver.example.yaml for the configuration options. The claim
+// requests with the manual behaviour are approved or rejected with:
+//
+//	curl -X POST http://<ip>:<port>/admin/claim/approve/<id>
+//	curl -X POST http://<ip>:<port>/admin/claim/reject/<id>
+//
+// They answer 409 if the request has already been approved or rejected.
+//
+// The claim requests are listed (optionally filtered by status: pending,
+// approved or rejected), and the claims of approved requests revoked, with:
+//
+//	curl http://<ip>:<port>/admin/claim/requests?status=<status>
+//	curl -X POST http://<ip>:<port>/admin/claim/revoke/<id>
+//
+// The endpoints of the additional issuers and verifiers are served under their
+// prefix, for example http://<ip>:<port>/<prefix>/admin/claim/requests.
+//
+// The admin endpoints are only served to localhost, unless adminToken is set:
+// then they are served to any address with the header
+// "Authorization: Bearer <adminToken>".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/iden3/go-iden3-core/components/idenpubonchain"
+	idenpubonchainlocal "github.com/iden3/go-iden3-core/components/idenpubonchain/local"
+	"github.com/iden3/go-iden3-core/db"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/iden3/iden3-mobile/go/mockupserver"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type zkFilesConfig struct {
+	Url             string `yaml:"url"`
+	Path            string `yaml:"path"`
+	ProvingKey      string `yaml:"provingKey"`
+	VerificationKey string `yaml:"verificationKey"`
+	WitnessCalcWASM string `yaml:"witnessCalcWASM"`
+}
+
+// load checks the ZK artifacts stored in Path, downloading the missing ones
+// from Url if it's set.
+func (z *zkFilesConfig) load() (*zkutils.ZkFiles, error) {
+	if err := os.MkdirAll(z.Path, 0700); err != nil {
+		return nil, err
+	}
+	if z.Url == "" {
+		for _, basename := range []string{
+			fmt.Sprintf("proving_key.%v", zkutils.ProvingKeyFormatGoBin),
+			"verification_key.json",
+			"circuit.wasm",
+		} {
+			filename := path.Join(z.Path, basename)
+			if _, err := os.Stat(filename); err != nil {
+				return nil, fmt.Errorf("Missing ZK artifact %v and no url to download it: %w", filename, err)
+			}
+		}
+	}
+	zkFiles := zkutils.NewZkFiles(z.Url, z.Path, zkutils.ProvingKeyFormatGoBin,
+		zkutils.ZkFilesHashes{
+			ProvingKey:      z.ProvingKey,
+			VerificationKey: z.VerificationKey,
+			WitnessCalcWASM: z.WitnessCalcWASM,
+		}, true)
+	if err := zkFiles.DownloadAll(); err != nil {
+		return nil, err
+	}
+	return zkFiles, nil
+}
+
+type config struct {
+	mockupserver.Conf `yaml:",inline"`
+	// BlockPeriod is the time between the blocks of the local smart contract
+	BlockPeriod time.Duration `yaml:"blockPeriod"`
+	ZkFiles     struct {
+		IdenState  zkFilesConfig `yaml:"idenState"`
+		Credential zkFilesConfig `yaml:"credential"`
+	} `yaml:"zkFiles"`
+}
+
+func loadConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config{BlockPeriod: 2 * time.Second}
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func main() {
+	configPath := flag.String("config", "mockupserver.yaml", "path of the configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.WithError(err).Fatal("loadConfig()")
+	}
+	zkFilesIdenState, err := cfg.ZkFiles.IdenState.load()
+	if err != nil {
+		log.WithError(err).Fatal("Error loading the ZK artifacts of the identity state circuit")
+	}
+	zkFilesCredential, err := cfg.ZkFiles.Credential.load()
+	if err != nil {
+		log.WithError(err).Fatal("Error loading the ZK artifacts of the credential circuit")
+	}
+	vk, err := zkFilesIdenState.VerificationKey()
+	if err != nil {
+		log.WithError(err).Fatal("zkFilesIdenState.VerificationKey()")
+	}
+
+	var clock chainClock
+	localIdenPubOnChain := idenpubonchainlocal.New(clock.Time, clock.Block, vk)
+	var idenPubOnChain idenpubonchain.IdenPubOnChainer = localIdenPubOnChain
+	var chainStorage db.Storage
+	if cfg.StorePath != "" {
+		// Keep the state transitions, as the local smart contract is in memory
+		if err := os.MkdirAll(cfg.StorePath, 0700); err != nil {
+			log.WithError(err).Fatal("os.MkdirAll()")
+		}
+		if chainStorage, err = db.NewLevelDbStorage(path.Join(cfg.StorePath, "chain"), false); err != nil {
+			log.WithError(err).Fatal("db.NewLevelDbStorage()")
+		}
+		if idenPubOnChain, err = newJournaledIdenPubOnChain(localIdenPubOnChain, &clock, chainStorage); err != nil {
+			log.WithError(err).Fatal("Error replaying the state transitions of the local smart contract")
+		}
+	}
+	// Mine a block of the local smart contract every BlockPeriod
+	miner := startMiner(&clock, localIdenPubOnChain, cfg.BlockPeriod)
+
+	server, err := mockupserver.Start(&cfg.Conf, idenPubOnChain, zkFilesIdenState, zkFilesCredential)
+	if err != nil {
+		log.WithError(err).Fatal("mockupserver.Start()")
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Info("Stopping mockup server")
+	if err := server.Shutdown(context.Background()); err != nil {
+		log.WithError(err).Error("server.Shutdown()")
+	}
+	// The server doesn't send state transitions anymore, so the local smart
+	// contract can be stopped
+	miner.Stop()
+	if chainStorage != nil {
+		chainStorage.Close()
+	}
+}
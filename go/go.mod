@@ -16,6 +16,8 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.1 // indirect
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/iden3/go-circom-prover-verifier v0.0.1
+	github.com/iden3/go-circom-witnesscalc v0.0.1
 	github.com/iden3/go-iden3-core v0.0.8
 	github.com/iden3/go-iden3-crypto v0.0.5
 	github.com/iden3/go-iden3-servers v0.0.2
@@ -31,5 +33,7 @@ require (
 	github.com/sirupsen/logrus v1.5.0
 	github.com/status-im/keycard-go v0.0.0-20200107115650-f38e9a19958e // indirect
 	github.com/stretchr/testify v1.5.1
+	github.com/tyler-smith/go-bip39 v1.0.2
 	gopkg.in/go-playground/validator.v9 v9.29.1
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -0,0 +1,180 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/iden3/go-iden3-core/components/idenpubonchain"
+	"github.com/iden3/go-iden3-core/db"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	contactsStorKey = "contacts"
+	contactIssuer   = "issuer"
+	contactVerifier = "verifier"
+)
+
+// recordContact stores the time of the last successful request to an issuer or a verifier
+func (i *Identity) recordContact(kind string) {
+	i.contactsM.Lock()
+	defer i.contactsM.Unlock()
+	contacts, err := i.loadContacts()
+	if err != nil {
+		log.WithError(err).Warn("Error loading the last contacts")
+		return
+	}
+	contacts[kind] = time.Now().Unix()
+	tx, err := i.storage.NewTx()
+	if err != nil {
+		log.WithError(err).Warn("Error storing the last contacts")
+		return
+	}
+	if err := db.StoreJSON(tx, []byte(contactsStorKey), contacts); err != nil {
+		log.WithError(err).Warn("Error storing the last contacts")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.WithError(err).Warn("Error storing the last contacts")
+	}
+}
+
+func (i *Identity) loadContacts() (map[string]int64, error) {
+	contacts := make(map[string]int64)
+	if err := db.LoadJSON(i.storage, []byte(contactsStorKey), &contacts); err != nil && err != db.ErrNotFound {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// DiagnosticsTicket is the state of a pending ticket in the diagnostics
+type DiagnosticsTicket struct {
+	Id          string
+	Type        string
+	LastChecked int64
+	Attempts    int
+	LastError   string
+}
+
+// Diagnostics is a snapshot of the state of an Identity. It doesn't contain keys, claims nor
+// credentials, and the errors are redacted like the log messages.
+type Diagnostics struct {
+	ID             string
+	Time           int64
+	PendingTickets []DiagnosticsTicket
+	// Events is the number of events stored in the event log
+	Events uint32
+	// UndeliveredEvents is the number of events waiting to be stored and sent to the host
+	UndeliveredEvents int
+	// Credentials is the number of credentials in the ClaimDB
+	Credentials int
+	// StorageBytes is the disk usage of the identity store, and ZkArtifactsBytes the one of the
+	// ZK artifacts in the shared store
+	StorageBytes     int64
+	ZkArtifactsBytes int64
+	// LastIssuerContact and LastVerifierContact are the unix times of the last successful
+	// requests, or 0 if there has been none
+	LastIssuerContact   int64
+	LastVerifierContact int64
+	Web3Connected       bool
+	Web3Error           string
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func (i *Identity) diagnostics() (*Diagnostics, error) {
+	d := &Diagnostics{
+		ID:             i.id.ID().String(),
+		Time:           time.Now().Unix(),
+		PendingTickets: []DiagnosticsTicket{},
+	}
+	tickets, err := i.Tickets.GetPending()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tickets {
+		d.PendingTickets = append(d.PendingTickets, DiagnosticsTicket{
+			Id:          t.Id,
+			Type:        t.Type,
+			LastChecked: t.LastChecked,
+			Attempts:    t.Attempts,
+			LastError:   redactLogString(t.LastError),
+		})
+	}
+	if d.Events, err = i.eventMan.EventLength(); err != nil {
+		return nil, err
+	}
+	d.UndeliveredEvents = len(i.eventMan.eventChIn)
+	if err := i.ClaimDB.storage.Iterate(func(key, value []byte) (bool, error) {
+		d.Credentials++
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	if d.StorageBytes, err = dirSize(i.storePath); err != nil {
+		return nil, err
+	}
+	if d.ZkArtifactsBytes, err = i.ZkArtifacts.Usage(); err != nil {
+		return nil, err
+	}
+	i.contactsM.Lock()
+	contacts, err := i.loadContacts()
+	i.contactsM.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	d.LastIssuerContact = contacts[contactIssuer]
+	d.LastVerifierContact = contacts[contactVerifier]
+	// An identity that hasn't published its state yet is not on chain, but the node answered
+	if _, err := i.idenPubOnChain.GetState(i.id.ID()); err != nil && err != idenpubonchain.ErrIdenNotOnChain {
+		d.Web3Error = redactLogString(err.Error())
+	} else {
+		d.Web3Connected = true
+	}
+	return d, nil
+}
+
+// Diagnostics returns a JSON snapshot of the state of the Identity, meant to be attached to bug
+// reports: the pending tickets with their attempts and last errors, the size of the event log and
+// the ClaimDB, the disk usage, the last successful contact with an issuer and a verifier and the
+// connectivity with the web3 node. The secrets are not included.
+func (i *Identity) Diagnostics() (string, error) {
+	if err := i.begin(); err != nil {
+		return "", err
+	}
+	defer i.end()
+	d, err := i.diagnostics()
+	if err != nil {
+		return "", err
+	}
+	dJSON, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return string(dJSON), nil
+}
+
+// CallbackDiagnostics is a interface used to get an asynchronous response from DiagnosticsWithCb
+type CallbackDiagnostics interface {
+	Fn(string, error)
+}
+
+// DiagnosticsWithCb is the asynchronous version of Diagnostics, as checking the connectivity with
+// the web3 node may take a while.
+func (i *Identity) DiagnosticsWithCb(c CallbackDiagnostics) {
+	i.async(func() { c.Fn(i.Diagnostics()) })
+}
@@ -0,0 +1,89 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/core/proof"
+	"github.com/stretchr/testify/require"
+)
+
+// testStateSwitch answers like testStateOnChain, or fails like an unreachable web3 node while
+// offline
+type testStateSwitch struct {
+	testStateOnChain
+	m       sync.Mutex
+	offline bool
+}
+
+func (s *testStateSwitch) setOffline(offline bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.offline = offline
+}
+
+func (s *testStateSwitch) GetState(id *core.ID) (*proof.IdenStateData, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.offline {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+	return s.testStateOnChain.GetState(id)
+}
+
+func TestDiagnostics(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "diagnosticsTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	onChain := &testStateSwitch{}
+	id, err := newIdentity(dir, sharedDir, "pass_TestDiagnostics", onChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil, "", &testEventHandler{})
+	require.Nil(t, err)
+	defer id.Stop()
+
+	diagnostics := func() *Diagnostics {
+		dJSON, err := id.Diagnostics()
+		require.Nil(t, err)
+		var d Diagnostics
+		require.Nil(t, json.Unmarshal([]byte(dJSON), &d))
+		return &d
+	}
+	d := diagnostics()
+	require.Equal(t, id.id.ID().String(), d.ID)
+	require.Empty(t, d.PendingTickets)
+	require.Equal(t, 0, d.Credentials)
+	require.Equal(t, int64(0), d.LastIssuerContact)
+	require.True(t, d.Web3Connected)
+	require.Greater(t, d.StorageBytes, int64(0))
+
+	// Pending tickets with their attempts and redacted errors. The tickets are not checked
+	// anymore, so the ticket is reported as stored.
+	id.stopCheckingTickets()
+	secret := strings.Repeat("ab", 32)
+	require.Nil(t, id.Tickets.Add([]Ticket{{
+		Id:        "pending",
+		Type:      TicketTypeTest,
+		Status:    TicketStatusPending,
+		Attempts:  3,
+		LastError: "Invalid claim " + secret,
+		handler:   &testTicketHandler{},
+	}}))
+	id.recordContact(contactIssuer)
+	onChain.setOffline(true)
+	d = diagnostics()
+	require.Len(t, d.PendingTickets, 1)
+	require.Equal(t, 3, d.PendingTickets[0].Attempts)
+	require.Equal(t, "Invalid claim "+logRedacted, d.PendingTickets[0].LastError)
+	require.NotEqual(t, int64(0), d.LastIssuerContact)
+	require.Equal(t, int64(0), d.LastVerifierContact)
+	require.False(t, d.Web3Connected)
+	require.Contains(t, d.Web3Error, "connection refused")
+}
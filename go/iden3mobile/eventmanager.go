@@ -23,6 +23,8 @@ type EventManager struct {
 	eventsStorage *db.StorageList
 	eventChIn     chan Event
 	stopCh        chan bool
+	stopOnce      sync.Once
+	done          chan struct{}
 	eventSend     Sender
 	m             sync.RWMutex
 }
@@ -36,6 +38,7 @@ func NewEventManager(storage db.Storage, eventQueue chan Event, s Sender) *Event
 		eventChIn:     eventQueue,
 		eventsStorage: sl,
 		stopCh:        make(chan bool),
+		done:          make(chan struct{}),
 		eventSend:     s,
 	}
 }
@@ -53,8 +56,11 @@ func (em *EventManager) Start() {
 	go em.controller()
 }
 
+// Stop stops the event manager after storing and sending the queued events, and waits for it.
+// It's safe to call Stop more than once, but only after Start.
 func (em *EventManager) Stop() {
-	em.stopCh <- true
+	em.stopOnce.Do(func() { close(em.stopCh) })
+	<-em.done
 }
 
 func (em *EventManager) EventLength() (uint32, error) {
@@ -88,23 +94,34 @@ func (em *EventManager) GetEvent(idx uint32) (*Event, error) {
 }
 
 func (em *EventManager) controller() {
-	// nPendingRequests := 0
+	defer close(em.done)
 	for {
 		select {
 		case <-em.stopCh:
-			// Stop loop
-			return
-		case ev := <-em.eventChIn:
-			// Store event
-			if err := em.storeEvent(ev); err != nil {
-				log.Error("Error storing event: ", err)
+			// Handle the queued events and stop loop
+			for {
+				select {
+				case ev := <-em.eventChIn:
+					em.handleEvent(ev)
+				default:
+					return
+				}
 			}
-			// Send event
-			em.eventSend.Send(&ev)
+		case ev := <-em.eventChIn:
+			em.handleEvent(ev)
 		}
 	}
 }
 
+func (em *EventManager) handleEvent(ev Event) {
+	// Store event
+	if err := em.storeEvent(ev); err != nil {
+		log.WithError(err).Error("Error storing event")
+	}
+	// Send event
+	em.eventSend.Send(&ev)
+}
+
 func (em *EventManager) storeEvent(ev Event) error {
 	em.m.Lock()
 	defer em.m.Unlock()
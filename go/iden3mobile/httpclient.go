@@ -3,6 +3,7 @@ package iden3mobile
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/dghubble/sling"
@@ -43,6 +44,14 @@ func (e ValidationError) Error() string {
 	return strings.TrimSpace(buff.String())
 }
 
+// StatusError is the error returned by DoRequest when the server answers with a status other than 2xx
+type StatusError struct {
+	httpclient.ServerError
+	StatusCode int
+}
+
+var validate = validator.New()
+
 type HttpClient struct {
 	httpclient.HttpClient
 }
@@ -51,13 +60,28 @@ func NewHttpClient(urlBase string) *HttpClient {
 	return &HttpClient{HttpClient: *httpclient.NewHttpClient(urlBase)}
 }
 
-// DoRequest performs an HTTP request
+// DoRequest performs an HTTP request. If the server doesn't answer with a 2xx status, the error
+// is a StatusError.
 func (p *HttpClient) DoRequest(s *sling.Sling, res interface{}) error {
-	err := p.HttpClient.DoRequest(s, res)
-	switch e := err.(type) {
-	case validator.ValidationErrors:
-		return NewValidationError(e)
-	default:
+	var serverError httpclient.ServerError
+	resp, err := s.Receive(res, &serverError)
+	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if !(200 <= resp.StatusCode && resp.StatusCode < 300) {
+		return StatusError{ServerError: serverError, StatusCode: resp.StatusCode}
+	}
+	if res != nil {
+		rv := reflect.ValueOf(res)
+		if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Struct {
+			if err := validate.Struct(res); err != nil {
+				if e, ok := err.(validator.ValidationErrors); ok {
+					return NewValidationError(e)
+				}
+				return err
+			}
+		}
+	}
+	return nil
 }
@@ -1,48 +1,75 @@
 package iden3mobile
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/iden3/go-iden3-core/components/idenpuboffchain"
 	"github.com/iden3/go-iden3-core/components/idenpuboffchain/readerhttp"
 	"github.com/iden3/go-iden3-core/components/idenpubonchain"
 	"github.com/iden3/go-iden3-core/core/claims"
 	"github.com/iden3/go-iden3-core/core/proof"
 	"github.com/iden3/go-iden3-core/db"
 	"github.com/iden3/go-iden3-core/identity/holder"
+	"github.com/iden3/go-iden3-core/identity/issuer"
 	babykeystore "github.com/iden3/go-iden3-core/keystore"
 	"github.com/iden3/go-iden3-core/merkletree"
 	zkutils "github.com/iden3/go-iden3-core/utils/zk"
 	"github.com/iden3/go-iden3-crypto/babyjub"
 	issuerMsg "github.com/iden3/go-iden3-servers-demo/servers/issuerdemo/messages"
 	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+	"github.com/iden3/iden3-mobile/go/auth"
+	"github.com/iden3/iden3-mobile/go/relayer"
 	log "github.com/sirupsen/logrus"
 )
 
-func init() {
-	log.SetLevel(log.DebugLevel)
-}
-
 type Identity struct {
 	id              *holder.Holder
+	storePath       string
 	sharedStorePath string
 	storage         db.Storage
 	keyStore        *babykeystore.KeyStore
 	ClaimDB         *ClaimDB
+	ZkArtifacts     *ZkArtifacts
 	Tickets         *Tickets
 	stopTickets     chan bool
+	stopTicketsOnce sync.Once
+	ticketsDone     chan struct{}
 	eventMan        *EventManager
+	// relayerUrl is empty for genesis only identities, that can't publish their state
+	relayerUrl string
+	// idStateZkDir is the directory of the ZK artifacts of the state transition circuit, pinned
+	// while the identity is loaded
+	idStateZkDir   string
+	selfClaimsM    sync.Mutex
+	idenPubOnChain idenpubonchain.IdenPubOnChainer
+	contactsM      sync.Mutex
+	// stopM protects stopping, which is set by Stop to reject new calls, and inFlight counts the
+	// calls and callbacks that Stop waits for before closing the storage.
+	stopM    sync.RWMutex
+	stopping bool
+	inFlight sync.WaitGroup
+	stopped  chan struct{}
 }
 
+var (
+	// ErrIdentityStopped is returned by the methods of an Identity after Stop has been called
+	ErrIdentityStopped = errors.New("Identity stopped")
+	// ErrStopTimeout is returned by StopWithTimeout when the calls in flight don't finish in time.
+	// The resources of the Identity are closed when they finish.
+	ErrStopTimeout = errors.New("Timeout waiting for the identity to stop")
+)
+
 const (
 	kOpStorKey           = "kOpComp"
+	relayerUrlStorKey    = "relayerUrl"
 	eventsStorKey        = "eventsKey"
 	storageSubPath       = "/idStore"
 	keyStorageSubPath    = "/idKeyStore"
@@ -51,6 +78,8 @@ const (
 	folderStore          = "store"
 	folderKeyStore       = "keystore"
 	folderZKArtifacts    = "ZKArtifacts"
+	claimDemoProofName   = "claimDemo"
+	stopTimeout          = 10 * time.Second
 )
 
 func isEmpty(path string) (bool, error) {
@@ -70,17 +99,36 @@ func isEmpty(path string) (bool, error) {
 // NewIdentity creates a new identity
 // this funciton is mapped as a constructor in Java.
 // NOTE: The storePath must be unique per Identity.
-// NOTE: Right now the extraGenesisClaims is useless.
+// NOTE: extraGenesisClaims are added to the genesis claims tree together with the claim of the operational key.
+// Each element is a claim serialized as the bytes of its merkletree entry (128 bytes), and its
+// revocation nonce is overwritten, as the nonces are assigned by the identity.
 func NewIdentity(storePath, sharedStorePath, pass, web3Url string, checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, eventHandler Sender) (*Identity, error) {
 	idenPubOnChain, err := loadIdenPubOnChain(web3Url)
 	if err != nil {
 		return nil, err
 	}
-	return newIdentity(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, extraGenesisClaims, eventHandler)
+	return newIdentity(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, extraGenesisClaims, nil, "", eventHandler)
+}
+
+// NewIdentityWithIdenPubOnChain is like NewIdentityWithRelayer but reads the
+// state of the identities from idenPubOnChain instead of a web3 url, so that a
+// local smart contract can be used in tests (see the iden3mobiletest package).
+// If relayerUrl is empty, the identity is genesis only.
+// NOTE: It's not available in the mobile bindings.
+func NewIdentityWithIdenPubOnChain(storePath, sharedStorePath, pass string, idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	relayerUrl string, checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, eventHandler Sender) (*Identity, error) {
+	return newIdentity(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, extraGenesisClaims, nil, relayerUrl, eventHandler)
 }
 
+// newIdentity creates a new identity. If kOpSk is nil, a random operational key is generated.
+// If relayerUrl is empty, the identity is genesis only: it can't add claims to its own tree nor publish its state.
 func newIdentity(storePath, sharedStorePath, pass string, idenPubOnChain idenpubonchain.IdenPubOnChainer,
-	checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, eventHandler Sender) (*Identity, error) {
+	checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, kOpSk *babyjub.PrivateKey, relayerUrl string,
+	eventHandler Sender) (*Identity, error) {
+	genesisClaims, err := parseExtraGenesisClaims(extraGenesisClaims)
+	if err != nil {
+		return nil, err
+	}
 	// Check that storePath points to an empty dir
 	if dirIsEmpty, err := isEmpty(storePath); !dirIsEmpty || err != nil {
 		if err == nil {
@@ -118,7 +166,12 @@ func newIdentity(storePath, sharedStorePath, pass string, idenPubOnChain idenpub
 		}
 	}()
 	// Create babyjub keys
-	kOpComp, err := keyStore.NewKey([]byte(pass))
+	var kOpComp *babyjub.PublicKeyComp
+	if kOpSk != nil {
+		kOpComp, err = keyStore.ImportKey(*kOpSk, []byte(pass))
+	} else {
+		kOpComp, err = keyStore.NewKey([]byte(pass))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +186,13 @@ func newIdentity(storePath, sharedStorePath, pass string, idenPubOnChain idenpub
 	if err := db.StoreJSON(tx, []byte(kOpStorKey), kOpComp); err != nil {
 		return nil, err
 	}
+	holderCfg := holder.ConfigDefault
+	if relayerUrl != "" {
+		holderCfg.GenesisOnly = false
+		if err := db.StoreJSON(tx, []byte(relayerUrlStorKey), relayerUrl); err != nil {
+			return nil, err
+		}
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
@@ -142,9 +202,9 @@ func newIdentity(storePath, sharedStorePath, pass string, idenPubOnChain idenpub
 	}
 	// Create new Identity (holder)
 	if _, err = holder.Create(
-		holder.ConfigDefault,
+		holderCfg,
 		kOpComp,
-		nil,
+		genesisClaims,
 		storage,
 		keyStore,
 	); err != nil {
@@ -164,6 +224,47 @@ func newIdentity(storePath, sharedStorePath, pass string, idenPubOnChain idenpub
 	return newIdentityLoad(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, eventHandler)
 }
 
+// parseClaim parses a claim serialized as the bytes of its merkletree entry.
+func parseClaim(claimBytes []byte) (claims.Claimer, error) {
+	entry, err := merkletree.NewEntryFromBytes(claimBytes)
+	if err != nil {
+		return nil, err
+	}
+	entrier, err := claims.NewClaimFromEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	claim, ok := entrier.(claims.Claimer)
+	if !ok {
+		return nil, errors.New("Unsupported claim type")
+	}
+	return claim, nil
+}
+
+// parseExtraGenesisClaims parses and validates the claims that will be added to the genesis claims tree.
+func parseExtraGenesisClaims(extraGenesisClaims *BytesArray) ([]claims.Claimer, error) {
+	if extraGenesisClaims == nil {
+		return nil, nil
+	}
+	genesisClaims := make([]claims.Claimer, 0, extraGenesisClaims.Len())
+	// The revocation nonce is assigned by the identity, so two claims that only differ on it are the same claim
+	seen := make(map[string]bool)
+	for i := 0; i < extraGenesisClaims.Len(); i++ {
+		claim, err := parseClaim(extraGenesisClaims.Get(i))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid extra genesis claim %v: %w", i, err)
+		}
+		claim.Metadata().RevNonce = 0
+		key := string(claim.Entry().Bytes())
+		if seen[key] {
+			return nil, fmt.Errorf("Invalid extra genesis claim %v: duplicated claim", i)
+		}
+		seen[key] = true
+		genesisClaims = append(genesisClaims, claim)
+	}
+	return genesisClaims, nil
+}
+
 // NewIdentityLoad loads an already created identity
 // this funciton is mapped as a constructor in Java
 func NewIdentityLoad(storePath, sharedStorePath, pass, web3Url string, checkTicketsPeriodMilis int, eventHandler Sender) (*Identity, error) {
@@ -174,6 +275,14 @@ func NewIdentityLoad(storePath, sharedStorePath, pass, web3Url string, checkTick
 	return newIdentityLoad(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, eventHandler)
 }
 
+// NewIdentityLoadWithIdenPubOnChain is like NewIdentityLoad but reads the
+// state of the identities from idenPubOnChain instead of a web3 url.
+// NOTE: It's not available in the mobile bindings.
+func NewIdentityLoadWithIdenPubOnChain(storePath, sharedStorePath, pass string, idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	checkTicketsPeriodMilis int, eventHandler Sender) (*Identity, error) {
+	return newIdentityLoad(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, eventHandler)
+}
+
 func newIdentityLoad(storePath, sharedStorePath, pass string, idenPubOnChain idenpubonchain.IdenPubOnChainer, checkTicketsPeriodMilis int, eventHandler Sender) (*Identity, error) {
 	// TODO: figure out how to diferentiate the two constructors from Java: https://github.com/iden3/iden3-mobile/issues/17#issuecomment-587374644
 	storage, err := loadStorage(path.Join(storePath, folderStore))
@@ -192,16 +301,42 @@ func newIdentityLoad(storePath, sharedStorePath, pass string, idenPubOnChain ide
 	if err := keyStore.UnlockKey(kOpComp, []byte(pass)); err != nil {
 		return nil, fmt.Errorf("Error unlocking babyjub key from keystore: %w", err)
 	}
+	// Identities that can publish their state need the ZK artifacts of the state transition
+	// circuit, and send their state transitions and off chain public data through the relayer
+	var iden *Identity
+	var relayerUrl, idStateZkDir string
+	var idenStateZkProofConf *issuer.IdenStateZkProofConf
+	var idenPubOffChainWriter idenpuboffchain.IdenPubOffChainWriter
+	if err := db.LoadJSON(storage, []byte(relayerUrlStorKey), &relayerUrl); err == nil {
+		relayerClient := relayer.NewClient(relayerUrl, idenPubOnChain,
+			func(domain string, payload []byte) (*auth.Signature, error) { return iden.sign(domain, payload) })
+		zkFiles, err := newIdStateZkFiles(relayerClient.ArtifactsUrl(), sharedStorePath)
+		if err != nil {
+			return nil, err
+		}
+		idStateZkDir = zkFiles.Path
+		idenStateZkProofConf = &issuer.IdenStateZkProofConf{
+			Levels: idStateProofLevels,
+			Files:  *zkFiles,
+		}
+		idenPubOffChainWriter = relayerClient
+		idenPubOnChain = relayerClient
+	} else if err != db.ErrNotFound {
+		return nil, err
+	}
 	// Load existing Identity (holder)
 	holdr, err := holder.Load(
 		storage,
 		keyStore,
 		idenPubOnChain,
-		nil,
-		nil,
+		idenStateZkProofConf,
+		idenPubOffChainWriter,
 		readerhttp.NewIdenPubOffChainHttp(),
 	)
 	if err != nil {
+		if idStateZkDir != "" {
+			unpinZkArtifacts(idStateZkDir)
+		}
 		return nil, err
 	}
 	// Init event manager
@@ -210,33 +345,119 @@ func newIdentityLoad(storePath, sharedStorePath, pass string, idenPubOnChain ide
 	em.Start()
 
 	// Init Identity
-	iden := &Identity{
+	iden = &Identity{
 		id:              holdr,
 		storage:         storage,
+		storePath:       storePath,
 		sharedStorePath: sharedStorePath,
 		keyStore:        keyStore,
 		Tickets:         NewTickets(storage.WithPrefix([]byte(ticketPrefix))),
 		stopTickets:     make(chan bool),
+		ticketsDone:     make(chan struct{}),
 		eventMan:        em,
 		ClaimDB:         NewClaimDB(storage.WithPrefix([]byte(credExistPrefix))),
-	}
-	go iden.Tickets.CheckPending(iden, eventQueue, time.Duration(checkTicketsPeriodMilis)*time.Millisecond, iden.stopTickets)
+		ZkArtifacts:     NewZkArtifacts(sharedStorePath),
+		relayerUrl:      relayerUrl,
+		idStateZkDir:    idStateZkDir,
+		idenPubOnChain:  idenPubOnChain,
+		stopped:         make(chan struct{}),
+	}
+	go func() {
+		defer close(iden.ticketsDone)
+		iden.Tickets.CheckPending(iden, eventQueue, time.Duration(checkTicketsPeriodMilis)*time.Millisecond, iden.stopTickets)
+	}()
 	return iden, nil
 }
 
-// Stop close all the open resources of the Identity
+// begin registers a call in flight, or returns ErrIdentityStopped if the Identity is stopping.
+// Every call to begin without error must be followed by a call to end.
+func (i *Identity) begin() error {
+	i.stopM.RLock()
+	defer i.stopM.RUnlock()
+	if i.stopping {
+		return ErrIdentityStopped
+	}
+	i.inFlight.Add(1)
+	return nil
+}
+
+func (i *Identity) end() {
+	i.inFlight.Done()
+}
+
+// async runs fn in a goroutine that Stop waits for. It's used by the WithCb methods, so that the
+// callbacks are not called after the Identity is stopped.
+func (i *Identity) async(fn func()) {
+	if err := i.begin(); err != nil {
+		// fn calls a method that returns ErrIdentityStopped to the callback
+		go fn()
+		return
+	}
+	go func() {
+		defer i.end()
+		fn()
+	}()
+}
+
+// Stop close all the open resources of the Identity, waiting up to stopTimeout for the calls in flight.
+// It's safe to call Stop more than once.
 func (i *Identity) Stop() {
-	log.Info("Stopping identity: ", i.id.ID())
-	defer i.storage.Close()
-	defer i.keyStore.Close()
-	i.stopTickets <- true
-	i.eventMan.Stop()
+	if err := i.StopWithTimeout(int(stopTimeout / time.Millisecond)); err != nil {
+		log.WithError(err).Warn("Error stopping identity")
+	}
+}
+
+// stopCheckingTickets stops checking the pending tickets and waits for the last check to finish,
+// without stopping the Identity.
+func (i *Identity) stopCheckingTickets() {
+	i.stopTicketsOnce.Do(func() { close(i.stopTickets) })
+	<-i.ticketsDone
+}
+
+// StopWithTimeout stops checking the pending tickets and waits for the ticket checks, calls
+// and callbacks in flight to finish before closing all the open resources of the Identity.
+// If they don't finish in timeoutMillis, ErrStopTimeout is returned and the resources are
+// closed later. After the first call, all the methods return ErrIdentityStopped, and calling
+// StopWithTimeout again waits again for the resources to be closed.
+func (i *Identity) StopWithTimeout(timeoutMillis int) error {
+	i.stopM.Lock()
+	stopping := i.stopping
+	i.stopping = true
+	i.stopM.Unlock()
+	if !stopping {
+		log.WithField("id", i.id.ID()).Info("Stopping identity")
+		i.stopTicketsOnce.Do(func() { close(i.stopTickets) })
+		go func() {
+			i.inFlight.Wait()
+			<-i.ticketsDone
+			// The events of the last ticket checks are sent before stopping the event manager
+			i.eventMan.Stop()
+			if err := i.keyStore.Close(); err != nil {
+				log.WithError(err).Error("keyStore.Close()")
+			}
+			i.storage.Close()
+			if i.idStateZkDir != "" {
+				unpinZkArtifacts(i.idStateZkDir)
+			}
+			close(i.stopped)
+		}()
+	}
+	select {
+	case <-i.stopped:
+		return nil
+	case <-time.After(time.Duration(timeoutMillis) * time.Millisecond):
+		return ErrStopTimeout
+	}
 }
 
 // RequestClaim sends a petition to issue a claim to an issuer.
 // This function will eventually trigger an event,
 // the returned ticket can be used to reference the event
 func (i *Identity) RequestClaim(baseUrl, data string) (*Ticket, error) {
+	if err := i.begin(); err != nil {
+		return nil, err
+	}
+	defer i.end()
 	// Warning: This only applies to the current used claim!
 	if len(data) > 16 {
 		return nil, errors.New("The data string cannot be longer than 16 chars")
@@ -247,14 +468,23 @@ func (i *Identity) RequestClaim(baseUrl, data string) (*Ticket, error) {
 		Type:   TicketTypeClaimReq,
 		Status: TicketStatusPending,
 	}
+	// Sign the request so the issuer knows that it comes from the holder of the ID
+	req := auth.ReqClaimRequest{
+		ReqClaimRequest: issuerMsg.ReqClaimRequest{
+			Value:    data,
+			Index:    data,
+			HolderID: i.id.ID(),
+		},
+	}
+	sig, err := i.sign(auth.DomainClaimRequest, auth.ClaimRequestPayload(&req.ReqClaimRequest))
+	if err != nil {
+		return nil, err
+	}
+	req.Signature = sig
 	httpClient := NewHttpClient(baseUrl)
 	res := issuerMsg.ResClaimRequest{}
 	if err := httpClient.DoRequest(httpClient.NewRequest().Path(
-		"claim/request").Post("").BodyJSON(&issuerMsg.ReqClaimRequest{
-		Value:    data,
-		Index:    data,
-		HolderID: i.id.ID(),
-	}), &res); err != nil {
+		"claim/request").Post("").BodyJSON(&req), &res); err != nil {
 		return nil, err
 	}
 	t.handler = &reqClaimHandler{
@@ -262,7 +492,8 @@ func (i *Identity) RequestClaim(baseUrl, data string) (*Ticket, error) {
 		BaseUrl: baseUrl,
 		Status:  string(issuerMsg.RequestStatusPending),
 	}
-	err := i.Tickets.Add([]Ticket{*t})
+	i.recordContact(contactIssuer)
+	err = i.Tickets.Add([]Ticket{*t})
 	return t, err
 }
 
@@ -271,12 +502,16 @@ type CallbackRequestClaim interface {
 }
 
 func (i *Identity) RequestClaimWithCb(baseUrl, data string, c CallbackRequestClaim) {
-	go func() { c.Fn(i.RequestClaim(baseUrl, data)) }()
+	i.async(func() { c.Fn(i.RequestClaim(baseUrl, data)) })
 }
 
 // ProveClaim sends a credentialValidity build from the given credentialExistance to a verifier.
 // The response should be true if the verified accepted the prove as valid
 func (i *Identity) ProveClaim(baseUrl string, credID string) (bool, error) {
+	if err := i.begin(); err != nil {
+		return false, err
+	}
+	defer i.end()
 	// Build credential validity
 	credVal, err := i.getCredentialValidity(credID)
 	if err != nil {
@@ -292,6 +527,7 @@ func (i *Identity) ProveClaim(baseUrl string, credID string) (bool, error) {
 		return false, err
 	}
 	// Success
+	i.recordContact(contactVerifier)
 	return true, nil
 }
 
@@ -314,31 +550,34 @@ type CallbackProveClaim interface {
 // ProveClaimWithCb sends a credentialValidity build from the given credentialExistance to a verifier.
 // The callback is used to check if the verifier has accepted the credential as valid in an async maner
 func (i *Identity) ProveClaimWithCb(baseUrl string, credID string, c CallbackProveClaim) {
-	go func() { c.Fn(i.ProveClaim(baseUrl, credID)) }()
+	i.async(func() { c.Fn(i.ProveClaim(baseUrl, credID)) })
 }
 
 // ProveClaimZK sends a credentialValidity build from the given credentialExistance to a verifier.
 // This method will generate a zero knowledge proof so the verifier can't see the content of the claim.
 // The response should be true if the verified accepted the prove as valid.
+// A fresh precomputed or cached proof is used instead of generating a new one.
 func (i *Identity) ProveClaimZK(baseUrl string, credID string) (bool, error) {
+	if err := i.begin(); err != nil {
+		return false, err
+	}
+	defer i.end()
+	return i.proveClaimZK(baseUrl, credID, nil, "credentialDemo/verifyzkp")
+}
+
+// genZkProofCredential generates a zero knowledge proof of the ownership of the given credentialExistance,
+// or of the query over its claim if query is not nil.
+// The ZK artifacts are downloaded from baseUrl if they are not in the shared store yet.
+func (i *Identity) genZkProofCredential(baseUrl string, credID string, query *ZkQuery) (*verifierMsg.ReqVerifyZkp, error) {
 	// Get credential existance
 	credExist, err := i.ClaimDB.GetCredExist(credID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// DBG BEGIN
-	// claimHex := make([]string, 8)
-	// for i := 0; i < 8; i++ {
-	// 	claimHex[i] = hex.EncodeToString(credExist.Claim.Data[i][:])
-	// }
-	// log.WithField("claim", claimHex).Debug("ProveClaimZK")
-	// DBG END
-
 	// Build credential ownership zk proof
 	// WARNING: this is a hardcoded proof generation for a specific claim/circuit.
 	// In the future we will add some mechanism that can deduce how to generate an arbitrary proof.
-	proofName := "claimDemo"
 	addInputs := func(claim *merkletree.Entry) func(inputs map[string]interface{}) error {
 		return func(inputs map[string]interface{}) error {
 			var metadata claims.Metadata
@@ -348,65 +587,53 @@ func (i *Identity) ProveClaimZK(baseUrl string, credID string) (bool, error) {
 			inputs["claimV1_3"] = []*big.Int{data[1*4+1].BigInt(), data[1*4+2].BigInt(), data[1*4+3].BigInt()}
 			inputs["id"] = i.id.ID().BigInt()
 			inputs["revNonce"] = new(big.Int).SetUint64(uint64(metadata.RevNonce))
-
-			// DBG BEGIN
-			in, err := zkutils.InputsToMapStrings(inputs)
-			if err != nil {
-				return err
-			}
-			inJSON, err := json.MarshalIndent(in, "", "  ")
-			if err != nil {
-				return err
-			}
-			fmt.Println(string(inJSON))
-			// DBG END
-
 			return nil
 		}
 	}
-	ZKPath := path.Join(i.sharedStorePath, folderZKArtifacts, proofName)
-	if err := os.MkdirAll(ZKPath, 0700); err != nil {
-		return false, err
+	circuit := zkCircuits[ZkCircuitClaimDemo]
+	inputs := addInputs(credExist.Claim)
+	if query != nil {
+		if circuit, err = getZkQueryCircuit(query.Circuit); err != nil {
+			return nil, err
+		}
+		if !query.satisfied(credExist.Claim) {
+			return nil, ErrZkQueryNotSatisfied
+		}
+		inputs = query.addInputs(i, credExist.Claim)
+	}
+	zkFiles, err := i.ZkArtifacts.fetch(circuit, baseUrl+circuit.urlPath)
+	if err != nil {
+		return nil, err
 	}
+	defer unpinZkArtifacts(zkFiles.Path)
 	zkProofCredOut, err := i.id.HolderGenZkProofCredential(
 		credExist,
-		addInputs(credExist.Claim),
+		inputs,
 		4,
 		16,
-		zkutils.NewZkFiles(
-			baseUrl+"credentialDemo/artifacts",
-			ZKPath,
-			zkutils.ProvingKeyFormatGoBin,
-			zkutils.ZkFilesHashes{
-				ProvingKey:      "bdefc89d07d1dfab75c43f09aedb9da876496c5c3967383337482e4c5ae4f7d3",
-				VerificationKey: "12a730890e85e33d8bf0f2e54db41dcff875c2dc49011d7e2a283185f47ac0de",
-				WitnessCalcWASM: "6b3c28c4842e04129674eb71dc84d76dd8b290c84987929d54d890b7b8bed211",
-			},
-			false,
-		),
+		zkFiles,
 	)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
-	// Send the CredentialValidity proof to Verifier
-	httpClient := NewHttpClient(baseUrl)
-	reqVerifyZkp := verifierMsg.ReqVerifyZkp{
+	return &verifierMsg.ReqVerifyZkp{
 		ZkProof:         &zkProofCredOut.ZkProofOut.Proof,
 		PubSignals:      zkProofCredOut.ZkProofOut.PubSignals,
 		IssuerID:        zkProofCredOut.IssuerID,
 		IdenStateBlockN: zkProofCredOut.IdenStateBlockN,
-	}
-	if err := httpClient.DoRequest(httpClient.NewRequest().Path(
-		"credentialDemo/verifyzkp").Post("").BodyJSON(&reqVerifyZkp), nil); err != nil {
-		return false, err
-	}
-	return true, nil
+	}, nil
 }
 
 // ProveClaimZKWithCb sends a credentialValidity build from the given credentialExistance to a verifier.
 // This method will generate a zero knowledge proof so the verifier can't see the content of the claim.
 // The callback is used to check if the verifier has accepted the credential as valid in an async maner
 func (i *Identity) ProveClaimZKWithCb(baseUrl string, credID string, c CallbackProveClaim) {
-	go func() { c.Fn(i.ProveClaimZK(baseUrl, credID)) }()
+	i.async(func() { c.Fn(i.ProveClaimZK(baseUrl, credID)) })
+}
+
+// newClaimDemoZkFiles returns the ZK artifacts of the claimDemo circuit. They are stored in the shared store
+// and downloaded from the verifier at baseUrl the first time they are needed.
+func newClaimDemoZkFiles(baseUrl, sharedStorePath string) (*zkutils.ZkFiles, error) {
+	circuit := zkCircuits[ZkCircuitClaimDemo]
+	return NewZkArtifacts(sharedStorePath).zkFiles(circuit, baseUrl+circuit.urlPath, false)
 }
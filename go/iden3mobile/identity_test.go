@@ -3,12 +3,15 @@ package iden3mobile
 import (
 	"io/ioutil"
 	"os"
-	"sync"
 	"testing"
-	"time"
 
 	idenpubonchainlocal "github.com/iden3/go-iden3-core/components/idenpubonchain/local"
+	"github.com/iden3/go-iden3-core/core/claims"
+	"github.com/iden3/go-iden3-core/core/genesis"
+	"github.com/iden3/go-iden3-core/merkletree"
 	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"github.com/iden3/iden3-mobile/go/testenv"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -23,85 +26,24 @@ type config struct {
 
 var c config
 var rmDirs []string
+var env *testenv.Env
 var idenPubOnChain *idenpubonchainlocal.IdenPubOnChain
-
-type TimeBlock struct {
-	timeNow  int64
-	blockNow uint64
-	rw       sync.RWMutex
-}
-
-func (tb *TimeBlock) SetTime(t int64) {
-	tb.rw.Lock()
-	defer tb.rw.Unlock()
-	tb.timeNow = t
-}
-
-func (tb *TimeBlock) SetBlock(n uint64) {
-	tb.rw.Lock()
-	defer tb.rw.Unlock()
-	tb.blockNow = n
-}
-
-func (tb *TimeBlock) AddTime(t int64) {
-	tb.rw.Lock()
-	defer tb.rw.Unlock()
-	tb.timeNow += t
-}
-
-func (tb *TimeBlock) AddBlock(n uint64) {
-	tb.rw.Lock()
-	defer tb.rw.Unlock()
-	tb.blockNow += n
-}
-
-func (tb *TimeBlock) Time() time.Time {
-	tb.rw.RLock()
-	defer tb.rw.RUnlock()
-	return time.Unix(tb.timeNow, 0)
-}
-
-func (tb *TimeBlock) Block() uint64 {
-	tb.rw.RLock()
-	defer tb.rw.RUnlock()
-	return tb.blockNow
-}
-
-var timeBlock TimeBlock
+var timeBlock *testenv.TimeBlock
 var zkFilesIdenState *zkutils.ZkFiles
 var zkFilesCredential *zkutils.ZkFiles
 
 func TestMain(m *testing.M) {
 	log.SetLevel(log.DebugLevel)
 
-	zkFilesIdenState = zkutils.NewZkFiles(
-		"http://161.35.72.58:9000/circuit-idstate/", "/tmp/iden3-test/idenstatezk-issuer",
-		zkutils.ProvingKeyFormatGoBin,
-		zkutils.ZkFilesHashes{
-			ProvingKey:      "37b6b3addd52faf9357f1496312e6a86af4f5c41c557cda9931468809d32c03c",
-			VerificationKey: "473952ff80aef85403005eb12d1e78a3f66b1cc11e7bd55d6bfe94e0b5577640",
-			WitnessCalcWASM: "8eafd9314c4d2664a23bf98a4f42cd0c29984960ae3544747ba5fbd60905c41f",
-		}, true)
-	if err := zkFilesIdenState.DownloadAll(); err != nil {
-		panic(err)
-	}
-
-	vk, err := zkFilesIdenState.VerificationKey()
-	if err != nil {
-		panic(err)
-	}
-
-	zkFilesCredential = zkutils.NewZkFiles(
-		"http://161.35.72.58:9000/credentialDemoWrapper", "/tmp/iden3-test/credentialzk",
-		zkutils.ProvingKeyFormatGoBin,
-		zkutils.ZkFilesHashes{
-			ProvingKey:      "bdefc89d07d1dfab75c43f09aedb9da876496c5c3967383337482e4c5ae4f7d3",
-			VerificationKey: "12a730890e85e33d8bf0f2e54db41dcff875c2dc49011d7e2a283185f47ac0de",
-			WitnessCalcWASM: "6b3c28c4842e04129674eb71dc84d76dd8b290c84987929d54d890b7b8bed211",
-		}, true)
-	if err := zkFilesCredential.DownloadAll(); err != nil {
-		panic(err)
-	}
+	// The ZK artifacts are the test circuits, unless IDEN3_TEST_ZK_DIR is set, and the identities
+	// expect their hashes
+	env = testenv.New()
+	zkCircuits[ZkCircuitIdState].hashes = env.ZkCircuitIdenState.Hashes
+	zkCircuits[ZkCircuitClaimDemo].hashes = env.ZkCircuitCredential.Hashes
+	idenPubOnChain = env.IdenPubOnChain
+	timeBlock = env.TimeBlock
+	zkFilesIdenState = env.ZkFilesIdenState
+	zkFilesCredential = env.ZkFilesCredential
 
 	c = config{
 		IssuerUrl:           "http://127.0.0.1:1234/",
@@ -110,11 +52,6 @@ func TestMain(m *testing.M) {
 		VerifierRetryPeriod: 6,
 		HolderTicketPeriod:  1000,
 	}
-	idenPubOnChain = idenpubonchainlocal.New(
-		timeBlock.Time,
-		timeBlock.Block,
-		vk,
-	)
 	// Create a tmp directory to store test files
 	// Run tests
 	result := m.Run()
@@ -139,7 +76,7 @@ func NewIdentityTest(storePath, sharedStorePath, pass string,
 		s = &testEventHandler{}
 	}
 	return newIdentity(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis,
-		extraGenesisClaims, s)
+		extraGenesisClaims, nil, "", s)
 }
 
 // NewIdentityTestLoad is like NewIdentityLoad but uses a local implementation of the smart contract in idenPubOnChain
@@ -176,3 +113,87 @@ func TestNewIdentity(t *testing.T) {
 	// Stop identity
 	id.Stop()
 }
+
+type testCallbackProveClaim chan error
+
+func (c testCallbackProveClaim) Fn(ok bool, err error) {
+	c <- err
+}
+
+func TestStopIdentity(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "identityTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestStopIdentity", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+
+	// Stop waits for the calls in flight
+	release := make(chan struct{})
+	id.async(func() { <-release })
+	require.Equal(t, ErrStopTimeout, id.StopWithTimeout(10))
+	_, err = id.SignMessage("domain", "message")
+	require.Equal(t, ErrIdentityStopped, err)
+	close(release)
+	require.Nil(t, id.StopWithTimeout(10000))
+
+	// Stop is idempotent, and the callbacks get the error
+	id.Stop()
+	cb := make(testCallbackProveClaim, 1)
+	id.ProveClaimWithCb("", "credID", cb)
+	require.Equal(t, ErrIdentityStopped, <-cb)
+	require.False(t, id.HasFreshProofZK("credID"))
+}
+
+func TestNewIdentityExtraGenesisClaims(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	newDir := func() string {
+		dir, err := ioutil.TempDir("", "identityTest")
+		require.Nil(t, err)
+		rmDirs = append(rmDirs, dir)
+		return dir
+	}
+	sk := babyjub.NewRandPrivKey()
+	claimKey := claims.NewClaimKeyBabyJub(sk.Public(), claims.BabyJubKeyTypeGeneric)
+	claimBasic := claims.NewClaimBasic([claims.IndexSlotLen]byte{1, 2, 3}, [claims.ValueSlotLen]byte{4, 5, 6})
+	extraGenesisClaims := NewBytesArray()
+	extraGenesisClaims.Append(claimKey.Entry().Bytes())
+	extraGenesisClaims.Append(claimBasic.Entry().Bytes())
+	id, err := NewIdentityTest(newDir(), sharedDir, "pass_TestNewIdentityExtraGenesisClaims", idenPubOnChain,
+		c.HolderTicketPeriod, extraGenesisClaims, nil)
+	require.Nil(t, err)
+	defer id.Stop()
+	// The ID is calculated from the genesis tree that includes the extra claims
+	kOp, err := id.id.KeyOperational().Decompress()
+	require.Nil(t, err)
+	claimKOp := claims.NewClaimKeyBabyJub(kOp, claims.BabyJubKeyTypeAuthorizeKSign)
+	claimKey.Metadata().RevNonce = 1
+	claimBasic.Metadata().RevNonce = 2
+	expectedID, err := genesis.CalculateIdGenesis(claimKOp, []merkletree.Entrier{claimKey, claimBasic})
+	require.Nil(t, err)
+	require.Equal(t, expectedID, id.id.ID())
+	// The identity can still prove the ownership of its operational key
+	keyProof, err := id.keyProof()
+	require.Nil(t, err)
+	require.Nil(t, keyProof.Verify(id.id.ID()))
+
+	// Invalid extra genesis claims
+	for _, invalid := range [][][]byte{
+		{[]byte{1, 2, 3}},
+		{make([]byte, 128)},
+		{claimBasic.Entry().Bytes(), claimBasic.Entry().Bytes()},
+	} {
+		extraGenesisClaims := NewBytesArray()
+		for _, bs := range invalid {
+			extraGenesisClaims.Append(bs)
+		}
+		_, err := NewIdentityTest(newDir(), sharedDir, "pass_TestNewIdentityExtraGenesisClaims", idenPubOnChain,
+			c.HolderTicketPeriod, extraGenesisClaims, nil)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,144 @@
+package iden3mobile
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Invitations are URIs (usually shared as QR codes or deep links) that tell the
+// Identity which flow to start against an issuer or a verifier:
+//   iden3://request-claim?url=<issuer base url>&data=<claim data>
+//   iden3://prove?url=<verifier base url>[&zk=true]
+// All the query values must be URL encoded.
+
+const (
+	InvitationScheme             = "iden3"
+	InvitationActionRequestClaim = "request-claim"
+	InvitationActionProve        = "prove"
+)
+
+// Invitation is a parsed and validated invitation URI.
+type Invitation struct {
+	Action  string
+	BaseUrl string
+	// Data is the data of the requested claim (request-claim only)
+	Data string
+	// ZK indicates that the verifier expects a zero knowledge proof (prove only)
+	ZK bool
+}
+
+func validateBaseUrl(baseUrl string) (string, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", fmt.Errorf("Invalid url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("The url must be an absolute http(s) url")
+	}
+	if !strings.HasSuffix(baseUrl, "/") {
+		baseUrl += "/"
+	}
+	return baseUrl, nil
+}
+
+// ParseInvitation parses and validates an invitation URI.
+func ParseInvitation(uri string) (*Invitation, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != InvitationScheme {
+		return nil, fmt.Errorf("Unexpected invitation scheme: %v", u.Scheme)
+	}
+	query := u.Query()
+	baseUrl, err := validateBaseUrl(query.Get("url"))
+	if err != nil {
+		return nil, err
+	}
+	inv := &Invitation{
+		Action:  u.Host,
+		BaseUrl: baseUrl,
+	}
+	switch inv.Action {
+	case InvitationActionRequestClaim:
+		inv.Data = query.Get("data")
+		if inv.Data == "" {
+			return nil, errors.New("The claim request invitation has no data")
+		}
+		// Warning: This only applies to the current used claim!
+		if len(inv.Data) > 16 {
+			return nil, errors.New("The data string cannot be longer than 16 chars")
+		}
+	case InvitationActionProve:
+		if zk := query.Get("zk"); zk != "" {
+			if inv.ZK, err = strconv.ParseBool(zk); err != nil {
+				return nil, fmt.Errorf("Invalid zk value: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unknown invitation action: %v", inv.Action)
+	}
+	return inv, nil
+}
+
+// URI encodes the invitation as an URI that can be parsed with ParseInvitation.
+func (inv *Invitation) URI() string {
+	query := url.Values{}
+	query.Set("url", inv.BaseUrl)
+	switch inv.Action {
+	case InvitationActionRequestClaim:
+		query.Set("data", inv.Data)
+	case InvitationActionProve:
+		if inv.ZK {
+			query.Set("zk", "true")
+		}
+	}
+	u := url.URL{
+		Scheme:   InvitationScheme,
+		Host:     inv.Action,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// RequestClaimFromInvitation sends the claim request described by a request-claim invitation.
+// It behaves like RequestClaim.
+func (i *Identity) RequestClaimFromInvitation(inv *Invitation) (*Ticket, error) {
+	if err := i.begin(); err != nil {
+		return nil, err
+	}
+	defer i.end()
+	if inv.Action != InvitationActionRequestClaim {
+		return nil, fmt.Errorf("Expected a %v invitation, got: %v", InvitationActionRequestClaim, inv.Action)
+	}
+	return i.RequestClaim(inv.BaseUrl, inv.Data)
+}
+
+// RequestClaimFromInvitationWithCb is the asynchronous version of RequestClaimFromInvitation.
+func (i *Identity) RequestClaimFromInvitationWithCb(inv *Invitation, c CallbackRequestClaim) {
+	i.async(func() { c.Fn(i.RequestClaimFromInvitation(inv)) })
+}
+
+// ProveClaimFromInvitation proves the given credential to the verifier of a prove invitation,
+// using ProveClaimZK or ProveClaim depending on what the verifier expects.
+func (i *Identity) ProveClaimFromInvitation(inv *Invitation, credID string) (bool, error) {
+	if err := i.begin(); err != nil {
+		return false, err
+	}
+	defer i.end()
+	if inv.Action != InvitationActionProve {
+		return false, fmt.Errorf("Expected a %v invitation, got: %v", InvitationActionProve, inv.Action)
+	}
+	if inv.ZK {
+		return i.ProveClaimZK(inv.BaseUrl, credID)
+	}
+	return i.ProveClaim(inv.BaseUrl, credID)
+}
+
+// ProveClaimFromInvitationWithCb is the asynchronous version of ProveClaimFromInvitation.
+func (i *Identity) ProveClaimFromInvitationWithCb(inv *Invitation, credID string, c CallbackProveClaim) {
+	i.async(func() { c.Fn(i.ProveClaimFromInvitation(inv, credID)) })
+}
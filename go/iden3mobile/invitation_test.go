@@ -0,0 +1,50 @@
+package iden3mobile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInvitation(t *testing.T) {
+	// Request claim
+	inv, err := ParseInvitation("iden3://request-claim?url=http%3A%2F%2F127.0.0.1%3A1234&data=foo")
+	require.Nil(t, err)
+	require.Equal(t, &Invitation{
+		Action:  InvitationActionRequestClaim,
+		BaseUrl: "http://127.0.0.1:1234/",
+		Data:    "foo",
+	}, inv)
+	inv2, err := ParseInvitation(inv.URI())
+	require.Nil(t, err)
+	require.Equal(t, inv, inv2)
+	// Prove
+	inv, err = ParseInvitation("iden3://prove?url=https%3A%2F%2Fverifier.iden3.io%2Fapi%2F&zk=true")
+	require.Nil(t, err)
+	require.Equal(t, &Invitation{
+		Action:  InvitationActionProve,
+		BaseUrl: "https://verifier.iden3.io/api/",
+		ZK:      true,
+	}, inv)
+	inv2, err = ParseInvitation(inv.URI())
+	require.Nil(t, err)
+	require.Equal(t, inv, inv2)
+	inv, err = ParseInvitation("iden3://prove?url=https%3A%2F%2Fverifier.iden3.io%2F")
+	require.Nil(t, err)
+	require.False(t, inv.ZK)
+
+	// Invalid invitations
+	for _, uri := range []string{
+		"https://request-claim?url=http%3A%2F%2F127.0.0.1%3A1234&data=foo",
+		"iden3://unknown?url=http%3A%2F%2F127.0.0.1%3A1234&data=foo",
+		"iden3://request-claim?url=http%3A%2F%2F127.0.0.1%3A1234",
+		"iden3://request-claim?url=http%3A%2F%2F127.0.0.1%3A1234&data=01234567890123456",
+		"iden3://request-claim?url=ftp%3A%2F%2F127.0.0.1&data=foo",
+		"iden3://request-claim?url=127.0.0.1%3A1234&data=foo",
+		"iden3://request-claim?data=foo",
+		"iden3://prove?url=http%3A%2F%2F127.0.0.1%3A1234&zk=maybe",
+	} {
+		_, err := ParseInvitation(uri)
+		require.Error(t, err, uri)
+	}
+}
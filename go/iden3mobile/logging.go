@@ -0,0 +1,189 @@
+package iden3mobile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The library logs through logrus. By default only the messages of level Info or higher are
+// written to stderr, and the secrets and private claim data are redacted from all the messages,
+// including the ones of the iden3 libraries. The host app can receive the messages, with their
+// structured fields, by setting a Logger.
+
+const (
+	LogLevelDebug = 1
+	LogLevelInfo  = 2
+	LogLevelWarn  = 3
+	LogLevelError = 4
+)
+
+// redactedLogFields are the keys of the log fields that are never written
+var redactedLogFields = map[string]bool{
+	"claim":      true,
+	"claimData":  true,
+	"inputs":     true,
+	"mnemonic":   true,
+	"pass":       true,
+	"password":   true,
+	"privateKey": true,
+	"revNonce":   true,
+	"seed":       true,
+}
+
+const logRedacted = "[REDACTED]"
+
+// logHexSecretRe matches hex strings long enough to be keys or claim elements
+var logHexSecretRe = regexp.MustCompile(`\b(0x)?[0-9a-fA-F]{64,}\b`)
+
+func redactLogString(s string) string {
+	return logHexSecretRe.ReplaceAllString(s, logRedacted)
+}
+
+// redactLogEntry returns the message and fields of the entry without secrets
+func redactLogEntry(entry *log.Entry) (string, log.Fields) {
+	fields := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if redactedLogFields[k] {
+			fields[k] = logRedacted
+		} else if err, ok := v.(error); ok {
+			fields[k] = redactLogString(err.Error())
+		} else {
+			fields[k] = redactLogString(fmt.Sprint(v))
+		}
+	}
+	return redactLogString(entry.Message), fields
+}
+
+// logFormatter redacts the entries before formatting them
+type logFormatter struct {
+	formatter log.Formatter
+}
+
+func (f *logFormatter) Format(entry *log.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message, redacted.Data = redactLogEntry(entry)
+	return f.formatter.Format(&redacted)
+}
+
+// LogFields are the structured fields of a log message, sorted by key
+type LogFields struct {
+	keys   []string
+	values []string
+}
+
+func newLogFields(fields log.Fields) *LogFields {
+	lf := &LogFields{keys: make([]string, 0, len(fields))}
+	for k := range fields {
+		lf.keys = append(lf.keys, k)
+	}
+	sort.Strings(lf.keys)
+	lf.values = make([]string, len(lf.keys))
+	for i, k := range lf.keys {
+		lf.values[i] = fmt.Sprint(fields[k])
+	}
+	return lf
+}
+
+// Len returns the number of fields
+func (lf *LogFields) Len() int {
+	return len(lf.keys)
+}
+
+// Key returns the key of the field at index
+func (lf *LogFields) Key(index int) string {
+	return lf.keys[index]
+}
+
+// Value returns the value of the field at index
+func (lf *LogFields) Value(index int) string {
+	return lf.values[index]
+}
+
+// Logger is a interface used to receive the log messages, for example to forward them to the
+// Android logcat. The level is one of the LogLevel constants, and the secrets are redacted from
+// the message and the fields.
+type Logger interface {
+	Log(level int, msg string, fields *LogFields)
+}
+
+// loggerHook sends the log entries to a Logger
+type loggerHook struct {
+	logger Logger
+}
+
+func (h *loggerHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *loggerHook) Fire(entry *log.Entry) error {
+	msg, fields := redactLogEntry(entry)
+	h.logger.Log(fromLogrusLevel(entry.Level), msg, newLogFields(fields))
+	return nil
+}
+
+var logging sync.Mutex
+
+func init() {
+	log.SetLevel(log.InfoLevel)
+	log.SetFormatter(&logFormatter{formatter: &log.TextFormatter{DisableColors: true}})
+}
+
+func toLogrusLevel(level int) (log.Level, error) {
+	switch level {
+	case LogLevelDebug:
+		return log.DebugLevel, nil
+	case LogLevelInfo:
+		return log.InfoLevel, nil
+	case LogLevelWarn:
+		return log.WarnLevel, nil
+	case LogLevelError:
+		return log.ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("Invalid log level: %v", level)
+	}
+}
+
+func fromLogrusLevel(level log.Level) int {
+	switch level {
+	case log.DebugLevel, log.TraceLevel:
+		return LogLevelDebug
+	case log.InfoLevel:
+		return LogLevelInfo
+	case log.WarnLevel:
+		return LogLevelWarn
+	default:
+		return LogLevelError
+	}
+}
+
+// SetLogLevel sets the minimum level (one of the LogLevel constants) of the logged messages.
+// The default level is LogLevelInfo.
+func SetLogLevel(level int) error {
+	l, err := toLogrusLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(l)
+	return nil
+}
+
+// SetLogger sends the log messages to logger instead of stderr. If logger is nil, the messages
+// are written to stderr again. The logger must not log through this library.
+func SetLogger(logger Logger) {
+	logging.Lock()
+	defer logging.Unlock()
+	hooks := make(log.LevelHooks)
+	if logger == nil {
+		log.SetOutput(os.Stderr)
+	} else {
+		hooks.Add(&loggerHook{logger: logger})
+		log.SetOutput(ioutil.Discard)
+	}
+	log.StandardLogger().ReplaceHooks(hooks)
+}
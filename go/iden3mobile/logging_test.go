@@ -0,0 +1,58 @@
+package iden3mobile
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type testLogger struct {
+	levels []int
+	msgs   []string
+	fields []map[string]string
+}
+
+func (l *testLogger) Log(level int, msg string, fields *LogFields) {
+	l.levels = append(l.levels, level)
+	l.msgs = append(l.msgs, msg)
+	m := make(map[string]string)
+	for i := 0; i < fields.Len(); i++ {
+		m[fields.Key(i)] = fields.Value(i)
+	}
+	l.fields = append(l.fields, m)
+}
+
+func TestLogging(t *testing.T) {
+	logger := &testLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	defer log.SetLevel(log.GetLevel())
+
+	// Levels
+	require.Error(t, SetLogLevel(0))
+	require.Nil(t, SetLogLevel(LogLevelWarn))
+	log.Info("info")
+	log.Warn("warn")
+	log.Error("error")
+	require.Equal(t, []int{LogLevelWarn, LogLevelError}, logger.levels)
+	require.Equal(t, []string{"warn", "error"}, logger.msgs)
+
+	// Structured fields
+	logger.msgs, logger.fields = nil, nil
+	log.WithField("ticket", "ticketID").WithField("pending", 2).Warn("Tickets pending")
+	require.Equal(t, map[string]string{"ticket": "ticketID", "pending": "2"}, logger.fields[0])
+
+	// Secrets and claim data are redacted
+	logger.msgs, logger.fields = nil, nil
+	require.Nil(t, SetLogLevel(LogLevelDebug))
+	secret := strings.Repeat("ab", 32)
+	log.WithField("claim", []string{"1", "2"}).WithField("key", "credID").Debug("Claim " + secret)
+	log.WithError(errors.New("Invalid key 0x" + secret)).Error("Error")
+	require.Len(t, logger.msgs, 2)
+	require.Equal(t, "Claim "+logRedacted, logger.msgs[0])
+	require.Equal(t, map[string]string{"claim": logRedacted, "key": "credID"}, logger.fields[0])
+	require.Equal(t, "Invalid key "+logRedacted, logger.fields[1]["error"])
+}
@@ -0,0 +1,78 @@
+package iden3mobile
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"errors"
+	"strings"
+
+	"github.com/iden3/go-iden3-core/components/idenpubonchain"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Identities created from a mnemonic (BIP-39 seed phrase) can be recovered on another device:
+// the operational key is derived deterministically from the BIP-39 seed as
+//   kOpSk = HMAC-SHA512(key = mnemonicKOpDerivationKey, msg = seed)[:32]
+// and, as the ID is derived from the genesis claims, the same mnemonic (and extraGenesisClaims)
+// always gives the same ID.
+
+const (
+	// mnemonicEntropyBits is the entropy of the generated mnemonics (24 words)
+	mnemonicEntropyBits = 256
+)
+
+var mnemonicKOpDerivationKey = []byte("iden3 babyjub kOp")
+
+// NewMnemonic generates a new random mnemonic that can be used to create a recoverable identity
+// with NewIdentityFromMnemonic.
+// WARNING: Anyone with access to the mnemonic can recover the keys of the identity.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// normalizeMnemonic removes the extra whitespace and capital letters that the user may
+// have introduced when typing the mnemonic.
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// mnemonicToKOpSk derives the operational babyjub private key from the mnemonic.
+func mnemonicToKOpSk(mnemonic string) (*babyjub.PrivateKey, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(normalizeMnemonic(mnemonic), "")
+	if err != nil {
+		return nil, errors.New("Invalid mnemonic")
+	}
+	mac := hmac.New(sha512.New, mnemonicKOpDerivationKey)
+	mac.Write(seed)
+	var sk babyjub.PrivateKey
+	copy(sk[:], mac.Sum(nil)[:len(sk)])
+	return &sk, nil
+}
+
+// NewIdentityFromMnemonic creates an identity with the operational key derived from the mnemonic.
+// Calling it with the same mnemonic and extraGenesisClaims recovers the same identity (ID and keys)
+// in a new storePath, for example on another device. The pass is only used to encrypt the key store.
+// this funciton is mapped as a constructor in Java.
+// NOTE: The storePath must be unique per Identity.
+// NOTE: Only the ID and keys are recovered, the credentials and tickets of the original identity are not.
+func NewIdentityFromMnemonic(storePath, sharedStorePath, pass, web3Url, mnemonic string, checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, eventHandler Sender) (*Identity, error) {
+	idenPubOnChain, err := loadIdenPubOnChain(web3Url)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentityFromMnemonic(storePath, sharedStorePath, pass, idenPubOnChain, mnemonic, checkTicketsPeriodMilis, extraGenesisClaims, eventHandler)
+}
+
+func newIdentityFromMnemonic(storePath, sharedStorePath, pass string, idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	mnemonic string, checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, eventHandler Sender) (*Identity, error) {
+	kOpSk, err := mnemonicToKOpSk(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, extraGenesisClaims, kOpSk, "", eventHandler)
+}
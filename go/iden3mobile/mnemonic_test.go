@@ -0,0 +1,56 @@
+package iden3mobile
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIdentityFromMnemonic(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	require.Nil(t, err)
+	require.Equal(t, 24, len(strings.Fields(mnemonic)))
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	newDir := func() string {
+		dir, err := ioutil.TempDir("", "mnemonicTest")
+		require.Nil(t, err)
+		rmDirs = append(rmDirs, dir)
+		return dir
+	}
+	// Create an identity from the mnemonic
+	id1, err := newIdentityFromMnemonic(newDir(), sharedDir, "pass_TestNewIdentityFromMnemonic_1", idenPubOnChain,
+		mnemonic, c.HolderTicketPeriod, NewBytesArray(), &testEventHandler{})
+	require.Nil(t, err)
+	defer id1.Stop()
+	// Recover it in another store, with a different password and typing errors in the mnemonic
+	id2, err := newIdentityFromMnemonic(newDir(), sharedDir, "pass_TestNewIdentityFromMnemonic_2", idenPubOnChain,
+		"  "+strings.ToUpper(mnemonic)+" ", c.HolderTicketPeriod, NewBytesArray(), &testEventHandler{})
+	require.Nil(t, err)
+	defer id2.Stop()
+	require.Equal(t, id1.id.ID(), id2.id.ID())
+	require.Equal(t, id1.id.KeyOperational(), id2.id.KeyOperational())
+	// A different mnemonic gives a different identity
+	mnemonic3, err := NewMnemonic()
+	require.Nil(t, err)
+	id3, err := newIdentityFromMnemonic(newDir(), sharedDir, "pass_TestNewIdentityFromMnemonic_3", idenPubOnChain,
+		mnemonic3, c.HolderTicketPeriod, NewBytesArray(), &testEventHandler{})
+	require.Nil(t, err)
+	defer id3.Stop()
+	require.NotEqual(t, id1.id.ID(), id3.id.ID())
+	// Invalid mnemonics
+	words := strings.Fields(mnemonic)
+	for _, m := range []string{
+		"",
+		strings.Join(words[:23], " "),
+		strings.Join(append(words[:23], "notaword"), " "),
+	} {
+		_, err = newIdentityFromMnemonic(newDir(), sharedDir, "pass", idenPubOnChain,
+			m, c.HolderTicketPeriod, NewBytesArray(), &testEventHandler{})
+		require.Error(t, err, m)
+	}
+}
@@ -22,3 +22,25 @@ func (ba *BytesArray) Get(i int) []byte {
 func (ba *BytesArray) Append(bs []byte) {
 	ba.array = append(ba.array, bs)
 }
+
+type StringArray struct {
+	array []string
+}
+
+func NewStringArray() *StringArray {
+	return &StringArray{
+		array: make([]string, 0),
+	}
+}
+
+func (sa *StringArray) Len() int {
+	return len(sa.array)
+}
+
+func (sa *StringArray) Get(i int) string {
+	return sa.array[i]
+}
+
+func (sa *StringArray) Append(s string) {
+	sa.array = append(sa.array, s)
+}
@@ -0,0 +1,315 @@
+package iden3mobile
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/iden3/go-iden3-core/core/proof"
+	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+)
+
+// A presentation is a proof (credential validity or zero knowledge proof) serialized
+// to be exchanged between devices without a server, typically through QR codes.
+// The JSON encoded presentation is compressed with DEFLATE, encoded in base64 (URL alphabet, no padding)
+// and split in chunks. Each chunk has the following format:
+//   iden3p:<checksum>:<index>/<total>:<data>
+// where checksum is the hex encoding of the first 4 bytes of the sha256 of the compressed presentation,
+// index starts at 1 and total is the number of chunks of the presentation.
+// A presentation only contains the proof: the verifier uses its own source of ZK artifacts to verify a ZkProof.
+
+const (
+	PresentationTypeCredentialValidity = "credentialValidity"
+	PresentationTypeZkProof            = "zkProof"
+	presentationChunkPrefix            = "iden3p"
+	presentationChecksumLen            = 4
+	// PresentationMinChunkLen is the minimum length allowed for a presentation chunk.
+	PresentationMinChunkLen = 64
+	// presentationMaxDataLen is the maximum length of the encoded data of a presentation, and
+	// presentationMaxLen the maximum length of the JSON encoded presentation
+	presentationMaxDataLen = 64 * 1024
+	presentationMaxLen     = 1024 * 1024
+	// presentationMaxChunks is the maximum number of chunks of a presentation: the header of a chunk
+	// takes less than 32 characters, so a chunk of PresentationMinChunkLen carries at least 32
+	// characters of data.
+	presentationMaxChunks = presentationMaxDataLen / 32
+)
+
+type presentation struct {
+	Type               string
+	CredentialValidity *proof.CredentialValidity `json:",omitempty"`
+	ZkProof            *verifierMsg.ReqVerifyZkp `json:",omitempty"`
+}
+
+func presentationChecksum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:presentationChecksumLen])
+}
+
+// encodePresentation serializes a presentation into chunks of at most maxChunkLen characters.
+func encodePresentation(p *presentation, maxChunkLen int) ([]string, error) {
+	if maxChunkLen < PresentationMinChunkLen {
+		return nil, fmt.Errorf("The chunk length can't be smaller than %v", PresentationMinChunkLen)
+	}
+	pJSON, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(pJSON); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	checksum := presentationChecksum(compressed.Bytes())
+	data := base64.RawURLEncoding.EncodeToString(compressed.Bytes())
+	if len(data) > presentationMaxDataLen {
+		return nil, errors.New("The presentation is too big")
+	}
+	// The header length depends on the number of chunks, so find the smallest amount of chunks that fits
+	for nChunks := 1; ; nChunks++ {
+		header := fmt.Sprintf("%s:%s:%d/%d:", presentationChunkPrefix, checksum, nChunks, nChunks)
+		dataLen := maxChunkLen - len(header)
+		if dataLen <= 0 {
+			return nil, errors.New("The chunk length is too small for the presentation size")
+		}
+		if (len(data)+dataLen-1)/dataLen > nChunks {
+			continue
+		}
+		chunks := make([]string, 0, nChunks)
+		for i := 0; i < nChunks; i++ {
+			start := i * dataLen
+			end := start + dataLen
+			if end > len(data) {
+				end = len(data)
+			}
+			chunks = append(chunks, fmt.Sprintf("%s:%s:%d/%d:%s",
+				presentationChunkPrefix, checksum, i+1, nChunks, data[start:end]))
+		}
+		return chunks, nil
+	}
+}
+
+// PresentationReader joins the chunks of a presentation scanned in any order.
+type PresentationReader struct {
+	checksum string
+	chunks   []string
+	nChunks  int
+	dataLen  int
+	m        sync.Mutex
+}
+
+// NewPresentationReader creates a reader for a new presentation
+// this funciton is mapped as a constructor in Java.
+func NewPresentationReader() *PresentationReader {
+	return &PresentationReader{}
+}
+
+// Add adds a scanned chunk to the presentation. Chunks that have already been added are ignored.
+// The returned value is true once all the chunks of the presentation have been added.
+func (pr *PresentationReader) Add(chunk string) (bool, error) {
+	pr.m.Lock()
+	defer pr.m.Unlock()
+	parts := strings.SplitN(chunk, ":", 4)
+	if len(parts) != 4 || parts[0] != presentationChunkPrefix {
+		return false, errors.New("The chunk is not part of an iden3 presentation")
+	}
+	checksum, position, data := parts[1], parts[2], parts[3]
+	idxTotal := strings.Split(position, "/")
+	if len(idxTotal) != 2 {
+		return false, errors.New("Malformed chunk position")
+	}
+	idx, err := strconv.Atoi(idxTotal[0])
+	if err != nil {
+		return false, fmt.Errorf("Malformed chunk index: %w", err)
+	}
+	total, err := strconv.Atoi(idxTotal[1])
+	if err != nil {
+		return false, fmt.Errorf("Malformed chunk total: %w", err)
+	}
+	if total < 1 || total > presentationMaxChunks {
+		return false, errors.New("Chunk total out of range")
+	}
+	if idx < 1 || idx > total {
+		return false, errors.New("Chunk index out of range")
+	}
+	if len(data) > presentationMaxDataLen {
+		return false, errors.New("The chunk is too big")
+	}
+	if pr.chunks == nil {
+		pr.checksum = checksum
+		pr.chunks = make([]string, total)
+	} else if checksum != pr.checksum || total != len(pr.chunks) {
+		return false, errors.New("The chunk belongs to a different presentation")
+	}
+	if pr.chunks[idx-1] == "" {
+		if pr.dataLen+len(data) > presentationMaxDataLen {
+			return false, errors.New("The presentation is too big")
+		}
+		pr.chunks[idx-1] = data
+		pr.dataLen += len(data)
+		pr.nChunks++
+	}
+	return pr.nChunks == len(pr.chunks), nil
+}
+
+// Missing returns the number of chunks that have not been added yet,
+// or -1 if no chunk has been added.
+func (pr *PresentationReader) Missing() int {
+	pr.m.Lock()
+	defer pr.m.Unlock()
+	if pr.chunks == nil {
+		return -1
+	}
+	return len(pr.chunks) - pr.nChunks
+}
+
+// Type returns the type of the presentation (PresentationTypeCredentialValidity or PresentationTypeZkProof).
+// All the chunks need to be added before calling this method.
+func (pr *PresentationReader) Type() (string, error) {
+	p, err := pr.presentation()
+	if err != nil {
+		return "", err
+	}
+	return p.Type, nil
+}
+
+func (pr *PresentationReader) presentation() (*presentation, error) {
+	pr.m.Lock()
+	defer pr.m.Unlock()
+	if pr.chunks == nil || pr.nChunks != len(pr.chunks) {
+		return nil, errors.New("The presentation is not complete")
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(strings.Join(pr.chunks, ""))
+	if err != nil {
+		return nil, err
+	}
+	if presentationChecksum(compressed) != pr.checksum {
+		return nil, errors.New("Presentation checksum mismatch")
+	}
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	pJSON, err := ioutil.ReadAll(io.LimitReader(r, presentationMaxLen+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(pJSON) > presentationMaxLen {
+		return nil, errors.New("The presentation is too big")
+	}
+	var p presentation
+	if err := json.Unmarshal(pJSON, &p); err != nil {
+		return nil, err
+	}
+	switch p.Type {
+	case PresentationTypeCredentialValidity:
+		if p.CredentialValidity == nil {
+			return nil, errors.New("The presentation doesn't contain a credential validity")
+		}
+	case PresentationTypeZkProof:
+		if p.ZkProof == nil {
+			return nil, errors.New("The presentation doesn't contain a zero knowledge proof")
+		}
+	default:
+		return nil, errors.New("Unknown presentation type")
+	}
+	return &p, nil
+}
+
+// CallbackPresentClaim is a interface used to get an asynchronous response from
+// PresentClaimWithCb and PresentClaimZKWithCb
+type CallbackPresentClaim interface {
+	Fn(*StringArray, error)
+}
+
+func newStringArrayFrom(ss []string) *StringArray {
+	sa := NewStringArray()
+	for _, s := range ss {
+		sa.Append(s)
+	}
+	return sa
+}
+
+// PresentClaim builds a credentialValidity from the given credentialExistance and serializes it
+// in chunks of at most maxChunkLen characters, so it can be shown to a verifier device as QR codes.
+func (i *Identity) PresentClaim(credID string, maxChunkLen int) (*StringArray, error) {
+	if err := i.begin(); err != nil {
+		return nil, err
+	}
+	defer i.end()
+	credVal, err := i.getCredentialValidity(credID)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := encodePresentation(&presentation{
+		Type:               PresentationTypeCredentialValidity,
+		CredentialValidity: credVal,
+	}, maxChunkLen)
+	if err != nil {
+		return nil, err
+	}
+	return newStringArrayFrom(chunks), nil
+}
+
+// PresentClaimWithCb is the asynchronous version of PresentClaim.
+func (i *Identity) PresentClaimWithCb(credID string, maxChunkLen int, c CallbackPresentClaim) {
+	i.async(func() { c.Fn(i.PresentClaim(credID, maxChunkLen)) })
+}
+
+// PresentClaimZK generates a zero knowledge proof of the given credentialExistance and serializes it
+// in chunks of at most maxChunkLen characters, so it can be shown to a verifier device as QR codes.
+// The ZK artifacts are downloaded from baseUrl if needed.
+// A fresh precomputed or cached proof is used instead of generating a new one.
+func (i *Identity) PresentClaimZK(baseUrl, credID string, maxChunkLen int) (*StringArray, error) {
+	if err := i.begin(); err != nil {
+		return nil, err
+	}
+	defer i.end()
+	zkp, _, err := i.zkProofCredential(baseUrl, credID, nil)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := encodePresentation(&presentation{
+		Type:    PresentationTypeZkProof,
+		ZkProof: zkp,
+	}, maxChunkLen)
+	if err != nil {
+		return nil, err
+	}
+	return newStringArrayFrom(chunks), nil
+}
+
+// PresentClaimZKWithCb is the asynchronous version of PresentClaimZK.
+func (i *Identity) PresentClaimZKWithCb(baseUrl, credID string, maxChunkLen int, c CallbackPresentClaim) {
+	i.async(func() { c.Fn(i.PresentClaimZK(baseUrl, credID, maxChunkLen)) })
+}
+
+// VerifyPresentation verifies a presentation once all its chunks have been added to the reader.
+// The verification key of a ZkProof is downloaded from the url set with SetZkArtifactsUrl, or
+// must be already in the shared store.
+func (v *Verifier) VerifyPresentation(pr *PresentationReader) *VerifierResult {
+	p, err := pr.presentation()
+	if err != nil {
+		return newVerifierResultErr(err)
+	}
+	switch p.Type {
+	case PresentationTypeCredentialValidity:
+		return v.verifyCredentialValidity(p.CredentialValidity)
+	default:
+		return v.verifyZkProofCredential(p.ZkProof, v.zkArtifactsUrl)
+	}
+}
@@ -0,0 +1,129 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	bn256 "github.com/ethereum/go-ethereum/crypto/bn256/cloudflare"
+	zktypes "github.com/iden3/go-circom-prover-verifier/types"
+	"github.com/iden3/go-iden3-core/core/proof"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresentationChunks(t *testing.T) {
+	var credExist proof.CredentialExistence
+	err := json.Unmarshal([]byte(cred1JSON), &credExist)
+	require.Nil(t, err)
+	p := &presentation{
+		Type:               PresentationTypeCredentialValidity,
+		CredentialValidity: &proof.CredentialValidity{CredentialExistence: credExist},
+	}
+	// Chunks too small
+	_, err = encodePresentation(p, PresentationMinChunkLen-1)
+	require.Error(t, err)
+	// Single chunk
+	chunks, err := encodePresentation(p, 4096)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(chunks))
+	// Multiple chunks
+	chunks, err = encodePresentation(p, 100)
+	require.Nil(t, err)
+	require.True(t, len(chunks) > 1)
+	for _, chunk := range chunks {
+		require.True(t, len(chunk) <= 100)
+	}
+	// Add the chunks in random order, with duplicates
+	pr := NewPresentationReader()
+	require.Equal(t, -1, pr.Missing())
+	shuffled := append([]string{}, chunks...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	for i, chunk := range shuffled {
+		_, err := pr.Type()
+		require.Error(t, err)
+		complete, err := pr.Add(chunk)
+		require.Nil(t, err)
+		require.Equal(t, i == len(shuffled)-1, complete)
+		complete, err = pr.Add(chunk)
+		require.Nil(t, err)
+		require.Equal(t, i == len(shuffled)-1, complete)
+		require.Equal(t, len(shuffled)-i-1, pr.Missing())
+	}
+	typ, err := pr.Type()
+	require.Nil(t, err)
+	require.Equal(t, PresentationTypeCredentialValidity, typ)
+	p2, err := pr.presentation()
+	require.Nil(t, err)
+	require.Equal(t, credExist.Claim, p2.CredentialValidity.CredentialExistence.Claim)
+	require.Equal(t, credExist.Id, p2.CredentialValidity.CredentialExistence.Id)
+
+	// Chunks from a different presentation are rejected
+	var credExist2 proof.CredentialExistence
+	err = json.Unmarshal([]byte(cred2JSON), &credExist2)
+	require.Nil(t, err)
+	chunks2, err := encodePresentation(&presentation{
+		Type:               PresentationTypeCredentialValidity,
+		CredentialValidity: &proof.CredentialValidity{CredentialExistence: credExist2},
+	}, 100)
+	require.Nil(t, err)
+	pr = NewPresentationReader()
+	_, err = pr.Add(chunks[0])
+	require.Nil(t, err)
+	_, err = pr.Add(chunks2[1])
+	require.Error(t, err)
+	// Malformed chunks
+	_, err = pr.Add("foo")
+	require.Error(t, err)
+	_, err = pr.Add("iden3p:00000000:0/3:AAAA")
+	require.Error(t, err)
+	// Crafted totals and indexes are rejected before allocating the chunks
+	for _, chunk := range []string{
+		"iden3p:00000000:1/999999999999:AAAA",
+		fmt.Sprintf("iden3p:00000000:1/%d:AAAA", presentationMaxChunks+1),
+		"iden3p:00000000:1/0:AAAA",
+		"iden3p:00000000:-1/3:AAAA",
+		"iden3p:00000000:4/3:AAAA",
+	} {
+		pr := NewPresentationReader()
+		_, err = pr.Add(chunk)
+		require.Error(t, err)
+		require.Equal(t, -1, pr.Missing())
+	}
+	// Verifying an incomplete presentation fails
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	verif, err := newVerifier(idenPubOnChain, sharedDir, 60)
+	require.Nil(t, err)
+	res := verif.VerifyPresentation(pr)
+	require.False(t, res.Success)
+
+	// The ZK artifacts are not downloaded from an url chosen by the prover
+	chunks, err = encodePresentation(&presentation{
+		Type: PresentationTypeZkProof,
+		ZkProof: &verifierMsg.ReqVerifyZkp{
+			ZkProof: &zktypes.Proof{
+				A: new(bn256.G1).ScalarBaseMult(big.NewInt(1)),
+				B: new(bn256.G2).ScalarBaseMult(big.NewInt(1)),
+				C: new(bn256.G1).ScalarBaseMult(big.NewInt(1)),
+			},
+			PubSignals: zkutils.PubSignals{big.NewInt(1)},
+			IssuerID:   credExist.Id,
+		},
+	}, 4096)
+	require.Nil(t, err)
+	pr = NewPresentationReader()
+	_, err = pr.Add(chunks[0])
+	require.Nil(t, err)
+	res = verif.VerifyPresentation(pr)
+	require.False(t, res.Success)
+	require.Equal(t, ErrZkArtifactsMissing.Error(), res.Error)
+	require.Error(t, verif.SetZkArtifactsUrl("ftp://example.com"))
+	require.Nil(t, verif.SetZkArtifactsUrl("http://example.com"))
+	require.Equal(t, "http://example.com/", verif.zkArtifactsUrl)
+}
@@ -0,0 +1,212 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/iden3/go-iden3-core/core/claims"
+	"github.com/iden3/go-iden3-core/db"
+	"github.com/iden3/go-iden3-core/merkletree"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	log "github.com/sirupsen/logrus"
+)
+
+// Identities created with a relayer are not genesis only: they can add claims to their own
+// claims tree (self claims) and publish their state. Once the new state is on chain, the
+// credentials of the self claims are added to the ClaimDB, so they can be proved as any other claim.
+
+const (
+	selfClaimsPendingStorKey = "selfClaimsPending"
+	idStateProofName         = "idState"
+	// idStateProofLevels is the number of levels of the claims tree supported by the state transition circuit
+	idStateProofLevels = 16
+)
+
+var ErrGenesisOnly = errors.New("The identity is genesis only, it must be created with a relayer to add self claims and publish its state")
+
+// NewIdentityWithRelayer creates a new identity that can add claims to its own tree and publish
+// its state through the relayer at relayerUrl. Otherwise it behaves like NewIdentity.
+// this funciton is mapped as a constructor in Java.
+// NOTE: The storePath must be unique per Identity.
+// NOTE: The ZK artifacts of the state transition circuit are downloaded from the relayer
+// and stored in the sharedStorePath.
+// NOTE: The state transitions are signed with the operational key and sent to the smart contract
+// by the relayer, as the identity doesn't have an ethereum account. web3Url is only used to read
+// the smart contract.
+func NewIdentityWithRelayer(storePath, sharedStorePath, pass, web3Url, relayerUrl string, checkTicketsPeriodMilis int, extraGenesisClaims *BytesArray, eventHandler Sender) (*Identity, error) {
+	relayerUrl, err := validateBaseUrl(relayerUrl)
+	if err != nil {
+		return nil, err
+	}
+	idenPubOnChain, err := loadIdenPubOnChain(web3Url)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(storePath, sharedStorePath, pass, idenPubOnChain, checkTicketsPeriodMilis, extraGenesisClaims, nil, relayerUrl, eventHandler)
+}
+
+// newIdStateZkFiles returns the ZK artifacts of the state transition circuit. They are stored in the shared store
+// and downloaded from url the first time they are needed. They can't be removed while the process runs, as they
+// are used by the loaded identity.
+func newIdStateZkFiles(url, sharedStorePath string) (*zkutils.ZkFiles, error) {
+	return NewZkArtifacts(sharedStorePath).zkFiles(zkCircuits[ZkCircuitIdState], url, true)
+}
+
+func (i *Identity) loadSelfClaimsPending() ([]*merkletree.Entry, error) {
+	var pending []*merkletree.Entry
+	if err := db.LoadJSON(i.storage, []byte(selfClaimsPendingStorKey), &pending); err == db.ErrNotFound {
+		return []*merkletree.Entry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (i *Identity) storeSelfClaimsPending(pending []*merkletree.Entry) error {
+	tx, err := i.storage.NewTx()
+	if err != nil {
+		return err
+	}
+	if err := db.StoreJSON(tx, []byte(selfClaimsPendingStorKey), pending); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddSelfClaim adds a claim to the claims tree of the identity, for example to authorize an
+// additional key or to state a profile attribute. The claim is serialized as the bytes of its
+// merkletree entry (128 bytes), and its revocation nonce is assigned by the identity.
+// The claim can't be proved until the identity state is published with PublishState.
+func (i *Identity) AddSelfClaim(claimBytes []byte) error {
+	if err := i.begin(); err != nil {
+		return err
+	}
+	defer i.end()
+	if i.relayerUrl == "" {
+		return ErrGenesisOnly
+	}
+	claim, err := parseClaim(claimBytes)
+	if err != nil {
+		return err
+	}
+	i.selfClaimsM.Lock()
+	defer i.selfClaimsM.Unlock()
+	if err := i.id.IssueClaim(claim); err != nil {
+		return err
+	}
+	pending, err := i.loadSelfClaimsPending()
+	if err != nil {
+		return err
+	}
+	return i.storeSelfClaimsPending(append(pending, claim.Entry()))
+}
+
+// CallbackPublishState is a interface used to get an asynchronous response from PublishStateWithCb
+type CallbackPublishState interface {
+	Fn(*Ticket, error)
+}
+
+// PublishState calculates the new state of the identity, including the self claims added since the
+// last publication, and publishes it. This function will eventually trigger an event once the state
+// is on chain, with the ids of the credentials of the published self claims in the ClaimDB.
+// Only one state can be published at a time.
+func (i *Identity) PublishState() (*Ticket, error) {
+	if err := i.begin(); err != nil {
+		return nil, err
+	}
+	defer i.end()
+	if i.relayerUrl == "" {
+		return nil, ErrGenesisOnly
+	}
+	i.selfClaimsM.Lock()
+	defer i.selfClaimsM.Unlock()
+	// Download the missing ZK artifacts of the state transition circuit, resuming any
+	// interrupted download
+	circuit := zkCircuits[ZkCircuitIdState]
+	zkFiles, err := i.ZkArtifacts.fetch(circuit, i.relayerUrl+circuit.urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unpinZkArtifacts(zkFiles.Path)
+	// Generates the state transition proof and publishes the state
+	if err := i.id.PublishState(); err != nil {
+		return nil, err
+	}
+	idenState, _ := i.id.IdenStatePending()
+	if idenState.Equals(&merkletree.HashZero) {
+		return nil, errors.New("The identity state hasn't changed since the last publication")
+	}
+	pending, err := i.loadSelfClaimsPending()
+	if err != nil {
+		return nil, err
+	}
+	t := &Ticket{
+		Id:     uuid.New().String(),
+		Type:   TicketTypeStatePublication,
+		Status: TicketStatusPending,
+		handler: &statePublicationHandler{
+			IdenState: idenState,
+			Claims:    pending,
+		},
+	}
+	// The pending self claims are moved to the ticket: clear them before adding the ticket, so
+	// that a failure doesn't leave them both pending and in the ticket, and restore them if the
+	// ticket can't be added
+	if err := i.storeSelfClaimsPending([]*merkletree.Entry{}); err != nil {
+		return nil, err
+	}
+	if err := i.Tickets.Add([]Ticket{*t}); err != nil {
+		if err := i.storeSelfClaimsPending(pending); err != nil {
+			log.WithError(err).Error("Error restoring the pending self claims")
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// PublishStateWithCb is the asynchronous version of PublishState.
+func (i *Identity) PublishStateWithCb(c CallbackPublishState) {
+	i.async(func() { c.Fn(i.PublishState()) })
+}
+
+type statePublicationHandler struct {
+	IdenState *merkletree.Hash
+	Claims    []*merkletree.Entry
+}
+
+type eventStatePublication struct {
+	IdenState *merkletree.Hash
+	CredIDs   []string
+}
+
+func (h *statePublicationHandler) isDone(id *Identity) (bool, string, error) {
+	if err := id.id.SyncIdenStatePublic(); err != nil {
+		return false, "", err
+	}
+	if idenStatePending, _ := id.id.IdenStatePending(); idenStatePending.Equals(h.IdenState) {
+		return false, "", nil
+	}
+	// The state is on chain, store the credentials of the self claims
+	credIDs := make([]string, 0, len(h.Claims))
+	for _, claim := range h.Claims {
+		credExist, err := id.id.GenCredentialExistence(claims.NewClaimGeneric(claim))
+		if err != nil {
+			return true, "{}", err
+		}
+		credID, err := id.ClaimDB.AddCredentialExistance(credExist)
+		if err != nil {
+			log.WithError(err).Error("Error storing credential existance")
+			return true, "{}", err
+		}
+		credIDs = append(credIDs, credID)
+	}
+	j, err := json.Marshal(eventStatePublication{
+		IdenState: h.IdenState,
+		CredIDs:   credIDs,
+	})
+	if err != nil {
+		return true, "{}", err
+	}
+	return true, string(j), nil
+}
@@ -0,0 +1,52 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/iden3/go-iden3-core/core/claims"
+	"github.com/iden3/go-iden3-core/merkletree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfClaimsGenesisOnly(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "selfClaimsTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestSelfClaimsGenesisOnly", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	claim := claims.NewClaimBasic([claims.IndexSlotLen]byte{1}, [claims.ValueSlotLen]byte{2})
+	require.Equal(t, ErrGenesisOnly, id.AddSelfClaim(claim.Entry().Bytes()))
+	_, err = id.PublishState()
+	require.Equal(t, ErrGenesisOnly, err)
+
+	// Invalid relayer url
+	_, err = NewIdentityWithRelayer(dir, sharedDir, "pass", "http://127.0.0.1:8545", "127.0.0.1:1234",
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Error(t, err)
+}
+
+func TestStatePublicationTicketJSON(t *testing.T) {
+	claim := claims.NewClaimBasic([claims.IndexSlotLen]byte{1}, [claims.ValueSlotLen]byte{2})
+	ticket := Ticket{
+		Id:     "foo",
+		Type:   TicketTypeStatePublication,
+		Status: TicketStatusPending,
+		handler: &statePublicationHandler{
+			IdenState: &merkletree.Hash{1, 2, 3},
+			Claims:    []*merkletree.Entry{claim.Entry()},
+		},
+	}
+	ticketJSON, err := json.Marshal(ticket)
+	require.Nil(t, err)
+	var ticket2 Ticket
+	require.Nil(t, json.Unmarshal(ticketJSON, &ticket2))
+	require.Equal(t, ticket.handler, ticket2.handler)
+}
@@ -0,0 +1,113 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iden3/go-iden3-core/db"
+	"github.com/iden3/go-iden3-core/merkletree"
+	"github.com/iden3/iden3-mobile/go/auth"
+)
+
+// Keys used by the holder (go-iden3-core issuer) to store the genesis proof of the operational key
+const (
+	holderGenesisClaimKOpMtpKey   = "genclaimkopmtp"
+	holderGenesisClaimTreeRootKey = "genclr"
+)
+
+// keyProof builds the proof that the operational key belongs to the identity.
+func (i *Identity) keyProof() (*auth.KeyProof, error) {
+	var mtp merkletree.Proof
+	if err := db.LoadJSON(i.storage, []byte(holderGenesisClaimKOpMtpKey), &mtp); err != nil {
+		return nil, err
+	}
+	var root merkletree.Hash
+	if err := db.LoadJSON(i.storage, []byte(holderGenesisClaimTreeRootKey), &root); err != nil {
+		return nil, err
+	}
+	return &auth.KeyProof{
+		KOp:            i.id.KeyOperational(),
+		ClaimsTreeRoot: &root,
+		Mtp:            &mtp,
+	}, nil
+}
+
+// sign signs the payload in the given domain with the operational key of the identity.
+func (i *Identity) sign(domain string, payload []byte) (*auth.Signature, error) {
+	keyProof, err := i.keyProof()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := auth.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	sig, err := i.keyStore.SignRaw(keyProof.KOp, auth.MessageToSign(domain, i.id.ID(), timestamp, nonce, payload))
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Signature{
+		KeyProof:  *keyProof,
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		Signature: sig,
+	}, nil
+}
+
+// SignMessage signs a message with the operational key of the identity, so the
+// app can authenticate the identity to a service (for example, to log in).
+// The domain identifies the service and the purpose of the signature (for example
+// "example.com/login"), and can't start with "iden3.", which is reserved.
+// The result is a JSON encoded SignedMessage that contains the ID, domain, message,
+// a timestamp, a random nonce and the signature of:
+//
+//	domain | 0x00 | ID (31 bytes) | timestamp (uint64 big endian) | nonce | 0x00 | sha256(message)
+//
+// together with the proof that the signing key belongs to the ID.
+func (i *Identity) SignMessage(domain, message string) (string, error) {
+	if err := i.begin(); err != nil {
+		return "", err
+	}
+	defer i.end()
+	if domain == "" {
+		return "", errors.New("The domain can't be empty")
+	}
+	if strings.HasPrefix(domain, auth.DomainReservedPrefix) {
+		return "", errors.New("The domain can't start with " + auth.DomainReservedPrefix)
+	}
+	sig, err := i.sign(domain, []byte(message))
+	if err != nil {
+		return "", err
+	}
+	signedMsg, err := json.Marshal(auth.SignedMessage{
+		ID:        i.id.ID(),
+		Domain:    domain,
+		Message:   message,
+		Signature: *sig,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(signedMsg), nil
+}
+
+// VerifySignature verifies a JSON encoded SignedMessage, built by SignMessage on any identity.
+// It checks that the message was signed in the given domain by the identity in the message,
+// no more than maxAgeSeconds ago. It returns the ID of the signer.
+func (i *Identity) VerifySignature(signedMessage, domain string, maxAgeSeconds int) (string, error) {
+	if err := i.begin(); err != nil {
+		return "", err
+	}
+	defer i.end()
+	var signedMsg auth.SignedMessage
+	if err := json.Unmarshal([]byte(signedMessage), &signedMsg); err != nil {
+		return "", err
+	}
+	if err := signedMsg.Verify(domain, time.Now(), time.Duration(maxAgeSeconds)*time.Second); err != nil {
+		return "", err
+	}
+	return signedMsg.ID.String(), nil
+}
@@ -0,0 +1,91 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/iden3-mobile/go/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignRequest(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "signingTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestSignRequest", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	payload := []byte("payload")
+	sig, err := id.sign(auth.DomainClaimRequest, payload)
+	require.Nil(t, err)
+	now := time.Now()
+	require.Nil(t, sig.Verify(auth.DomainClaimRequest, id.id.ID(), payload, now, time.Minute))
+	// Different payload
+	require.Equal(t, auth.ErrSignatureInvalid,
+		sig.Verify(auth.DomainClaimRequest, id.id.ID(), []byte("other payload"), now, time.Minute))
+	// Different domain
+	require.Equal(t, auth.ErrSignatureInvalid,
+		sig.Verify("other domain", id.id.ID(), payload, now, time.Minute))
+	// Expired
+	require.Equal(t, auth.ErrSignatureExpired,
+		sig.Verify(auth.DomainClaimRequest, id.id.ID(), payload, now.Add(2*time.Minute), time.Minute))
+	// Different identity
+	otherID := core.NewID(core.TypeBJP0, [27]byte{1, 2, 3})
+	require.Equal(t, auth.ErrKeyProofId,
+		sig.Verify(auth.DomainClaimRequest, &otherID, payload, now, time.Minute))
+}
+
+func TestSignMessage(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir1, err := ioutil.TempDir("", "signingTest1")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir1)
+	dir2, err := ioutil.TempDir("", "signingTest2")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir2)
+	id1, err := NewIdentityTest(dir1, sharedDir, "pass_TestSignMessage_1", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id1.Stop()
+	id2, err := NewIdentityTest(dir2, sharedDir, "pass_TestSignMessage_2", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id2.Stop()
+
+	// Reserved and empty domains
+	_, err = id1.SignMessage(auth.DomainClaimRequest, "hello")
+	require.Error(t, err)
+	_, err = id1.SignMessage("", "hello")
+	require.Error(t, err)
+
+	// id2 verifies a message signed by id1
+	signedMsg, err := id1.SignMessage("example.com/login", "challenge 1234")
+	require.Nil(t, err)
+	signer, err := id2.VerifySignature(signedMsg, "example.com/login", 60)
+	require.Nil(t, err)
+	require.Equal(t, id1.id.ID().String(), signer)
+	// Wrong domain
+	_, err = id2.VerifySignature(signedMsg, "example.org/login", 60)
+	require.Equal(t, auth.ErrDomainMismatch, err)
+	// Tampered message
+	var sm auth.SignedMessage
+	require.Nil(t, json.Unmarshal([]byte(signedMsg), &sm))
+	sm.Message = "challenge 4321"
+	tampered, err := json.Marshal(sm)
+	require.Nil(t, err)
+	_, err = id2.VerifySignature(string(tampered), "example.com/login", 60)
+	require.Equal(t, auth.ErrSignatureInvalid, err)
+	// Malformed
+	_, err = id2.VerifySignature("{}", "example.com/login", 60)
+	require.Error(t, err)
+}
@@ -25,14 +25,16 @@ type TicketType string
 type TicketStatus string
 
 const (
-	TicketTypeClaimReq    = "RequestClaim"
-	TicketTypeTest        = "test ticket"
-	TicketStatusDone      = "Done"
-	TicketStatusDoneError = "Done with error"
-	TicketStatusPending   = "Pending"
-	TicketStatusCancel    = "Canceled"
-	ticketsToCancelKey    = "ticketsToCancelKey"
-	ticketsKey            = "ticketsKey"
+	TicketTypeClaimReq         = "RequestClaim"
+	TicketTypeStatePublication = "StatePublication"
+	TicketTypeZkArtifacts      = "ZkArtifacts"
+	TicketTypeTest             = "test ticket"
+	TicketStatusDone           = "Done"
+	TicketStatusDoneError      = "Done with error"
+	TicketStatusPending        = "Pending"
+	TicketStatusCancel         = "Canceled"
+	ticketsToCancelKey         = "ticketsToCancelKey"
+	ticketsKey                 = "ticketsKey"
 )
 
 type Ticket struct {
@@ -40,6 +42,10 @@ type Ticket struct {
 	LastChecked int64
 	Type        string
 	Status      string
+	// Attempts is the number of times that the ticket has been checked
+	Attempts int
+	// LastError is the error of the last check, if any
+	LastError   string
 	handler     ticketInterface
 	HandlerJSON json.RawMessage
 }
@@ -75,7 +81,7 @@ func (ts *Tickets) Add(tickets []Ticket) error {
 	if err != nil {
 		return err
 	}
-	log.Debug("Adding / Updating ", len(tickets), " tickets")
+	log.WithField("tickets", len(tickets)).Debug("Adding / Updating tickets")
 	for _, ticket := range tickets {
 		if err := db.StoreJSON(tx, []byte(ticket.Id), ticket); err != nil {
 			return err
@@ -138,6 +144,9 @@ func (ts *Tickets) removeTicketsToCancel(cancelledIds []string) error {
 	return ts.storeTicketsToCancel(ticketsToCancelCleaned)
 }
 
+// CheckPending checks the pending tickets every checkPendingPeriod, sending an event to eventCh for
+// each resolved ticket, until stopCh is closed. The tickets that are not being checked yet when
+// stopCh is closed are checked again the next time.
 func (ts *Tickets) CheckPending(id *Identity, eventCh chan Event, checkPendingPeriod time.Duration, stopCh chan bool) {
 	// TODO: give more control to native host (check now, ...): Impl ctx context, Check out futures @ rust for inspiration.
 	for {
@@ -153,22 +162,32 @@ func (ts *Tickets) CheckPending(id *Identity, eventCh chan Event, checkPendingPe
 		idsToCancel, err := ts.getTicketsToCancel()
 		ts.m.Unlock()
 		if err != nil {
-			log.Error("Error geting cancelled ids: ", err)
-			time.Sleep(checkPendingPeriod)
+			log.WithError(err).Error("Error geting cancelled ids")
+			select {
+			case <-stopCh:
+				log.Info("Stopping check pending tickets routine")
+				return
+			case <-time.After(checkPendingPeriod):
+			}
 			continue
 		}
 		tickets, err := ts.GetPending()
 		if err != nil {
 			// Should cause panic instead?
-			log.Error("Error loading pending tickets: ", err)
-			time.Sleep(checkPendingPeriod)
+			log.WithError(err).Error("Error loading pending tickets")
+			select {
+			case <-stopCh:
+				log.Info("Stopping check pending tickets routine")
+				return
+			case <-time.After(checkPendingPeriod):
+			}
 			continue
 		}
 		var wg sync.WaitGroup
 		nPendingTickets := len(tickets)
 		checkedTickets := make(chan Ticket, nPendingTickets)
 		events := make(chan Event, nPendingTickets)
-		log.Debug("Checking ", nPendingTickets, " pending tickets")
+		log.WithField("pending", nPendingTickets).Debug("Checking pending tickets")
 		for _, ticket := range tickets {
 			// If cancelled, cancel
 			isCancelled := false
@@ -184,14 +203,25 @@ func (ts *Tickets) CheckPending(id *Identity, eventCh chan Event, checkPendingPe
 				// cehck next ticket, this one is cancelled
 				continue
 			}
+			// Don't start new checks when stopping
+			select {
+			case <-stopCh:
+				continue
+			default:
+			}
 			// Check ticket
 			wg.Add(1)
 			go func(t Ticket) {
 				defer wg.Done()
 				isDone, data, err := t.handler.isDone(id)
+				t.Attempts++
+				t.LastError = ""
+				if err != nil {
+					t.LastError = err.Error()
+				}
 				if isDone {
 					// Resolve ticket
-					log.Info("Sending event for ticket: " + t.Id)
+					log.WithField("ticket", t.Id).Info("Sending event for ticket")
 					events <- Event{
 						Type:     t.Type,
 						TicketId: t.Id,
@@ -207,7 +237,7 @@ func (ts *Tickets) CheckPending(id *Identity, eventCh chan Event, checkPendingPe
 					checkedTickets <- t
 				} else {
 					if err != nil {
-						log.Error("Error handling ticket: "+t.Id, err)
+						log.WithError(err).WithField("ticket", t.Id).Error("Error handling ticket")
 					}
 					// Update ticket last checked time
 					t.LastChecked = int64(time.Now().Unix())
@@ -221,7 +251,7 @@ func (ts *Tickets) CheckPending(id *Identity, eventCh chan Event, checkPendingPe
 		idsToCancel, err = ts.getTicketsToCancel()
 		ts.m.Unlock()
 		if err != nil {
-			log.Error("Error geting cancelled ids: ", err)
+			log.WithError(err).Error("Error geting cancelled ids")
 		}
 		// Update tickets
 		close(checkedTickets)
@@ -243,31 +273,29 @@ func (ts *Tickets) CheckPending(id *Identity, eventCh chan Event, checkPendingPe
 				nResolvedTickets++
 			}
 		}
-		log.Debug("Done checking tickets. ", nResolvedTickets, " / ", nPendingTickets, " pending tickets has been resolved.")
+		log.WithField("resolved", nResolvedTickets).WithField("pending", nPendingTickets).Debug("Done checking tickets")
 		if len(ticketsToUpdate) > 0 {
 			if err := ts.Add(ticketsToUpdate); err != nil {
-				log.Error("Error updating tickets. Will check them next iteration.")
+				log.WithError(err).Error("Error updating tickets. Will check them next iteration.")
 			}
 		}
 		if len(canceledTicketIds) > 0 {
-			log.Debug(len(canceledTicketIds), " tickets have been cancelled.")
+			log.WithField("cancelled", len(canceledTicketIds)).Debug("Tickets have been cancelled")
 			if err := ts.removeTicketsToCancel(canceledTicketIds); err != nil {
-				log.Error("Error updating tickets to cancel: ", err)
+				log.WithError(err).Error("Error updating tickets to cancel")
 			}
 		}
 		close(events)
 		for ev := range events {
 			eventCh <- ev
 		}
-		// Should stop?
+		// Sleep until the next check, or stop
 		select {
 		case <-stopCh:
 			log.Info("Stopping check pending tickets routine")
 			return
-		default:
+		case <-time.After(checkPendingPeriod):
 		}
-		// Sleep
-		time.Sleep(checkPendingPeriod)
 	}
 }
 
@@ -289,6 +317,10 @@ func (t *Ticket) UnmarshalJSON(b []byte) error {
 	switch t.Type {
 	case TicketTypeClaimReq:
 		t.handler = &reqClaimHandler{}
+	case TicketTypeStatePublication:
+		t.handler = &statePublicationHandler{}
+	case TicketTypeZkArtifacts:
+		t.handler = &zkArtifactsHandler{}
 	case TicketTypeTest:
 		t.handler = &testTicketHandler{}
 	default:
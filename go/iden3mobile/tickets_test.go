@@ -99,12 +99,18 @@ func newTestTicketSystem(dir string, firstTime bool) (*Tickets, *EventManager, c
 	return ts, em, eventCh, stopTs, &storage, nil
 }
 
-func startTestTicketSystem(ts *Tickets, eventCh chan Event, stopTs chan bool) {
-	go ts.CheckPending(nil, eventCh, time.Duration(c.HolderTicketPeriod)*time.Millisecond, stopTs)
+func startTestTicketSystem(ts *Tickets, eventCh chan Event, stopTs chan bool) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ts.CheckPending(nil, eventCh, time.Duration(c.HolderTicketPeriod)*time.Millisecond, stopTs)
+	}()
+	return done
 }
 
-func stopTestTicketSystem(em *EventManager, stopTs chan bool, storage db.Storage) {
-	stopTs <- true
+func stopTestTicketSystem(em *EventManager, stopTs chan bool, done chan struct{}, storage db.Storage) {
+	close(stopTs)
+	<-done
 	em.Stop()
 	storage.Close()
 }
@@ -122,10 +128,10 @@ func TestTicketSystem(t *testing.T) {
 
 	ts1, em1, eventCh1, stopTs1, storage1, err := newTestTicketSystem(dir1, true)
 	require.Nil(t, err)
-	startTestTicketSystem(ts1, eventCh1, stopTs1)
+	done1 := startTestTicketSystem(ts1, eventCh1, stopTs1)
 	ts2, em2, eventCh2, stopTs2, storage2, err := newTestTicketSystem(dir2, true)
 	require.Nil(t, err)
-	startTestTicketSystem(ts2, eventCh2, stopTs2)
+	done2 := startTestTicketSystem(ts2, eventCh2, stopTs2)
 
 	// Add tickets
 	// Succes ticket before stop
@@ -161,10 +167,10 @@ func TestTicketSystem(t *testing.T) {
 	eventHandler(testGetEventWithTimeOut(em2, 1, nAtempts, period))
 
 	// Stop ticket system, and create new ones *without starting*
-	stopTestTicketSystem(em1, stopTs1, *storage1)
+	stopTestTicketSystem(em1, stopTs1, done1, *storage1)
 	ts1, em1, eventCh1, stopTs1, storage1, err = newTestTicketSystem(dir1, false)
 	require.Nil(t, err)
-	stopTestTicketSystem(em2, stopTs2, *storage2)
+	stopTestTicketSystem(em2, stopTs2, done2, *storage2)
 	ts2, em2, eventCh2, stopTs2, storage2, err = newTestTicketSystem(dir2, false)
 	require.Nil(t, err)
 
@@ -177,8 +183,8 @@ func TestTicketSystem(t *testing.T) {
 	require.Nil(t, ts2.Add([]Ticket{id2After1, id2After2}))
 
 	// Start ticket system
-	startTestTicketSystem(ts1, eventCh1, stopTs1)
-	startTestTicketSystem(ts2, eventCh2, stopTs2)
+	done1 = startTestTicketSystem(ts1, eventCh1, stopTs1)
+	done2 = startTestTicketSystem(ts2, eventCh2, stopTs2)
 
 	// Get "after" events
 	eventHandler(testGetEventWithTimeOut(em1, 2, nAtempts, period))
@@ -209,6 +215,6 @@ func TestTicketSystem(t *testing.T) {
 		require.Nil(t, err)
 	}
 	// Stop ticket system
-	stopTestTicketSystem(em1, stopTs1, *storage1)
-	stopTestTicketSystem(em2, stopTs2, *storage2)
+	stopTestTicketSystem(em1, stopTs1, done1, *storage1)
+	stopTestTicketSystem(em2, stopTs2, done2, *storage2)
 }
@@ -44,6 +44,7 @@ func (h *reqClaimHandler) checkClaimStatus(id *Identity) (bool, string, error) {
 		fmt.Sprintf("claim/status/%v", h.Id)).Get(""), &res); err != nil {
 		return true, "{}", err
 	}
+	id.recordContact(contactIssuer)
 	switch res.Status {
 	case issuerMsg.RequestStatusPending:
 		return false, "", nil
@@ -78,6 +79,7 @@ func (h *reqClaimHandler) checkClaimCredential(id *Identity) (bool, string, erro
 	}), &res); err != nil {
 		return true, "{}", err
 	}
+	id.recordContact(contactIssuer)
 	switch res.Status {
 	case issuerMsg.ClaimtStatusNotYet:
 		return false, "", nil
@@ -85,13 +87,13 @@ func (h *reqClaimHandler) checkClaimCredential(id *Identity) (bool, string, erro
 		// Check that credential match the issued claim
 		if !h.Claim.Equal(res.Credential.Claim) {
 			err := errors.New("The received credential doesn't match the issued claim")
-			log.Error(err)
+			log.WithError(err).Error("Invalid credential")
 			return true, "{}", err
 		}
 		// Add credential to the identity
 		credID, err := id.ClaimDB.AddCredentialExistance(res.Credential)
 		if err != nil {
-			log.Error("Error storing credential existance", err)
+			log.WithError(err).Error("Error storing credential existance")
 			return true, "{}", err
 		}
 		// Send event with success
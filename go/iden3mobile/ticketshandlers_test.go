@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -15,8 +16,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/iden3/go-iden3-core/components/idenpuboffchain"
+	"github.com/iden3/go-iden3-core/core/claims"
 	"github.com/iden3/go-iden3-core/core/proof"
+	issuerMsg "github.com/iden3/go-iden3-servers-demo/servers/issuerdemo/messages"
+	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+	"github.com/iden3/iden3-mobile/go/auth"
 	"github.com/iden3/iden3-mobile/go/mockupserver"
+	"github.com/iden3/iden3-mobile/go/relayer"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,6 +31,7 @@ import (
 
 var id1ClaimID string
 var id2ClaimID string
+var id3SelfClaimIDs []string
 var eventFromId = 0
 
 func holderEventHandler(ev *Event) {
@@ -52,34 +60,33 @@ func holderEventHandler(ev *Event) {
 			panic("Event from unexpected identity")
 		}
 		return
+	case TicketTypeStatePublication:
+		d := &eventStatePublication{}
+		if err := json.Unmarshal([]byte(ev.Data), d); err != nil {
+			panic(err)
+		}
+		if eventFromId != 3 {
+			panic("Event from unexpected identity")
+		}
+		id3SelfClaimIDs = d.CredIDs
+		return
 	default:
 		panic("Unexpected event")
 	}
 }
 
 func TestHolderHandlers(t *testing.T) {
+	env.RequireZk(t)
 	// Sync idenPubOnChain every 2 seconds
-	go func() {
-		for {
-			log.Info("idenPubOnChain.Sync()")
-			timeBlock.AddTime(100)
-			timeBlock.AddBlock(10)
-			idenPubOnChain.Sync()
-			time.Sleep(2 * time.Second)
-		}
-	}()
+	defer env.StartSync(2*time.Second, 10, 100)()
 
 	// // Start mockup server
-	server := mockupserver.Serve(t, &mockupserver.Conf{
+	server := env.Serve(t, &mockupserver.Conf{
 		IP:                "127.0.0.1",
 		Port:              "1234",
 		TimeToAproveClaim: 1 * time.Second,
 		TimeToPublish:     2 * time.Second,
-	},
-		idenPubOnChain,
-		zkFilesIdenState,
-		zkFilesCredential,
-	)
+	})
 	time.Sleep(1 * time.Second)
 
 	expectedEvents = make(map[string]testEvent)
@@ -93,6 +100,9 @@ func TestHolderHandlers(t *testing.T) {
 	dir2, err := ioutil.TempDir("", "holderTest2")
 	require.Nil(t, err)
 	rmDirs = append(rmDirs, dir2)
+	dir3, err := ioutil.TempDir("", "holderTest3")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir3)
 
 	id1, err := NewIdentityTest(dir1, sharedDir, "pass_TestHolder_1", idenPubOnChain,
 		c.HolderTicketPeriod, NewBytesArray(), nil)
@@ -105,18 +115,37 @@ func TestHolderHandlers(t *testing.T) {
 	require.Nil(t, err)
 	expectedEvents[t1.Id] = testEvent{Typ: t1.Type}
 
-	t2, err := id2.RequestClaim(c.IssuerUrl, randomBase64String(16))
+	inv, err := ParseInvitation((&Invitation{
+		Action:  InvitationActionRequestClaim,
+		BaseUrl: c.IssuerUrl,
+		Data:    randomBase64String(16),
+	}).URI())
+	require.Nil(t, err)
+	t2, err := id2.RequestClaimFromInvitation(inv)
 	require.Nil(t, err)
 	expectedEvents[t2.Id] = testEvent{Typ: t2.Type}
+	// Create an identity with the relayer of the mockup server and publish a self claim
+	id3, err := newIdentity(dir3, sharedDir, "pass_TestHolder_3", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil, c.IssuerUrl+"relayer/", nil)
+	require.Nil(t, err)
+	selfClaim := claims.NewClaimBasic([claims.IndexSlotLen]byte{1}, [claims.ValueSlotLen]byte{2})
+	require.Nil(t, id3.AddSelfClaim(selfClaim.Entry().Bytes()))
+	t3, err := id3.PublishState()
+	require.Nil(t, err)
+	expectedEvents[t3.Id] = testEvent{Typ: t3.Type}
 	// Test that tickets are persisted by reloading identities
 	id1.Stop()
 	id2.Stop()
+	id3.Stop()
 	id1, err = NewIdentityTestLoad(dir1, sharedDir, "pass_TestHolder_1", idenPubOnChain,
 		c.HolderTicketPeriod, nil)
 	require.Nil(t, err)
 	id2, err = NewIdentityTestLoad(dir2, sharedDir, "pass_TestHolder_2", idenPubOnChain,
 		c.HolderTicketPeriod, nil)
 	require.Nil(t, err)
+	id3, err = NewIdentityTestLoad(dir3, sharedDir, "pass_TestHolder_3", idenPubOnChain,
+		c.HolderTicketPeriod, nil)
+	require.Nil(t, err)
 	// Wait for the events that will get triggered on issuer response
 	nAtempts := 1000 // TODO: go back to 10 atempts
 	period := time.Duration(c.HolderTicketPeriod) * time.Millisecond
@@ -124,6 +153,19 @@ func TestHolderHandlers(t *testing.T) {
 	holderEventHandler(testGetEventWithTimeOut(id1.eventMan, 0, nAtempts, period))
 	eventFromId = 2
 	holderEventHandler(testGetEventWithTimeOut(id2.eventMan, 0, nAtempts, period))
+	eventFromId = 3
+	holderEventHandler(testGetEventWithTimeOut(id3.eventMan, 0, nAtempts, period))
+	require.Equal(t, 1, len(id3SelfClaimIDs))
+	// The public data published through the relayer can only be replaced by its identity
+	relayerClient := NewHttpClient(c.IssuerUrl + "relayer/")
+	publicDataPath := fmt.Sprintf("%v/%v", relayer.PathIdenPublicData, id3.id.ID())
+	var blobs idenpuboffchain.PublicDataBlobs
+	require.Nil(t, relayerClient.DoRequest(relayerClient.NewRequest().
+		Path(publicDataPath+"/laststate").Get(""), &blobs))
+	sig, err := id1.sign(auth.DomainPublicData, relayer.PublicDataPayload(&blobs.IdenState))
+	require.Nil(t, err)
+	require.Error(t, relayerClient.DoRequest(relayerClient.NewRequest().Path(publicDataPath).Post("").
+		BodyJSON(relayer.ReqPublicData{PublicData: &blobs, Signature: sig}), nil))
 	// Prove Claims
 	isSuccess, err := id1.ProveClaim(c.VerifierUrl, id1ClaimID[:])
 	require.True(t, isSuccess)
@@ -131,6 +173,9 @@ func TestHolderHandlers(t *testing.T) {
 	isSuccess, err = id2.ProveClaim(c.VerifierUrl, id2ClaimID[:])
 	require.True(t, isSuccess)
 	require.NoError(t, err)
+	isSuccess, err = id3.ProveClaim(c.VerifierUrl, id3SelfClaimIDs[0])
+	require.True(t, isSuccess)
+	require.NoError(t, err)
 	// Prove Claims with ZK
 	isSuccess, err = id1.ProveClaimZK(c.VerifierUrl, id1ClaimID[:])
 	require.NoError(t, err)
@@ -138,14 +183,417 @@ func TestHolderHandlers(t *testing.T) {
 	isSuccess, err = id2.ProveClaimZK(c.VerifierUrl, id2ClaimID[:])
 	require.NoError(t, err)
 	require.True(t, isSuccess)
+	// Verify proofs with the offline verifier
+	verif, err := newVerifier(idenPubOnChain, sharedDir, 30*60)
+	require.Nil(t, err)
+	credVal, err := id1.getCredentialValidity(id1ClaimID)
+	require.Nil(t, err)
+	credValJSON, err := json.Marshal(credVal)
+	require.Nil(t, err)
+	res := verif.VerifyCredentialValidity(string(credValJSON))
+	require.True(t, res.Success, res.Error)
+	require.Equal(t, credVal.CredentialExistence.Id.String(), res.IssuerID)
+	zkp, err := id2.genZkProofCredential(c.VerifierUrl, id2ClaimID, nil)
+	require.Nil(t, err)
+	zkpJSON, err := json.Marshal(zkp)
+	require.Nil(t, err)
+	res = verif.VerifyZkProofCredential(string(zkpJSON), c.VerifierUrl)
+	require.True(t, res.Success, res.Error)
+	// Present claims as QR chunks and verify them
+	for _, present := range []func() (*StringArray, error){
+		func() (*StringArray, error) { return id1.PresentClaim(id1ClaimID, 512) },
+		func() (*StringArray, error) { return id2.PresentClaimZK(c.VerifierUrl, id2ClaimID, 512) },
+	} {
+		chunks, err := present()
+		require.Nil(t, err)
+		pr := NewPresentationReader()
+		for j := 0; j < chunks.Len(); j++ {
+			_, err := pr.Add(chunks.Get(j))
+			require.Nil(t, err)
+		}
+		res = verif.VerifyPresentation(pr)
+		require.True(t, res.Success, res.Error)
+	}
 	// Stop identities
 	id1.Stop()
 	id2.Stop()
+	id3.Stop()
 
 	err = server.Shutdown(context.Background())
 	require.Nil(t, err)
 }
 
+type testScenariosEventHandler struct {
+	events chan *Event
+}
+
+func (ha *testScenariosEventHandler) Send(ev *Event) {
+	ha.events <- ev
+}
+
+func TestHolderHandlersScenarios(t *testing.T) {
+	env.RequireZk(t)
+	// Sync idenPubOnChain every 2 seconds
+	stopSync := env.StartSync(2*time.Second, 10, 100)
+	defer stopSync()
+
+	dataReject := randomBase64String(16)
+	dataStatusError := randomBase64String(16)
+	dataMalformedJSON := randomBase64String(16)
+	dataSlow := randomBase64String(16)
+	dataNeverPublish := randomBase64String(16)
+	dataCredentialMismatch := randomBase64String(16)
+	server := env.Serve(t, &mockupserver.Conf{
+		IP:                "127.0.0.1",
+		Port:              "1235",
+		TimeToAproveClaim: 1 * time.Second,
+		TimeToPublish:     2 * time.Second,
+		Behaviours: map[string]mockupserver.Behaviour{
+			dataReject:             mockupserver.BehaviourReject,
+			dataStatusError:        mockupserver.BehaviourStatusError,
+			dataMalformedJSON:      mockupserver.BehaviourMalformedJSON,
+			dataSlow:               mockupserver.BehaviourSlow,
+			dataNeverPublish:       mockupserver.BehaviourNeverPublish,
+			dataCredentialMismatch: mockupserver.BehaviourCredentialMismatch,
+		},
+		SlowResponse: 3 * time.Second,
+	})
+	time.Sleep(1 * time.Second)
+	issuerUrl := "http://127.0.0.1:1235/"
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "holderScenariosTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	ha := &testScenariosEventHandler{events: make(chan *Event, 16)}
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestHolderScenarios", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), ha)
+	require.Nil(t, err)
+
+	// Request a claim for each scenario
+	tickets := make(map[string]string)
+	for _, data := range []string{dataReject, dataStatusError, dataMalformedJSON,
+		dataSlow, dataNeverPublish, dataCredentialMismatch} {
+		ticket, err := id.RequestClaim(issuerUrl, data)
+		require.Nil(t, err)
+		tickets[ticket.Id] = data
+	}
+	// Wait for the events of all the scenarios but the one that is never published
+	events := make(map[string]*Event)
+	for len(events) < len(tickets)-1 {
+		select {
+		case ev := <-ha.events:
+			events[tickets[ev.TicketId]] = ev
+		case <-time.After(2 * time.Minute):
+			require.FailNow(t, "Events not received", "received %v of %v", len(events), len(tickets)-1)
+		}
+	}
+	// Rejected requests finish without credential
+	ev := events[dataReject]
+	require.NotNil(t, ev)
+	require.Nil(t, ev.Err)
+	var evReject eventReqClaim
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &evReject))
+	require.Nil(t, evReject.Claim)
+	require.Equal(t, "", evReject.CredID)
+	// Server errors, malformed responses and mismatching credentials finish with error
+	for _, data := range []string{dataStatusError, dataMalformedJSON, dataCredentialMismatch} {
+		ev := events[data]
+		require.NotNil(t, ev)
+		require.Error(t, ev.Err)
+	}
+	// Slow responses finish with the credential
+	ev = events[dataSlow]
+	require.NotNil(t, ev)
+	require.Nil(t, ev.Err)
+	var evSlow eventReqClaim
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &evSlow))
+	isSuccess, err := id.ProveClaim(issuerUrl, evSlow.CredID)
+	require.NoError(t, err)
+	require.True(t, isSuccess)
+	// The claim that is never published stays pending
+	require.Nil(t, events[dataNeverPublish])
+	pending, err := id.Tickets.GetPending()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pending))
+	require.Equal(t, dataNeverPublish, tickets[pending[0].Id])
+
+	id.Stop()
+	err = server.Shutdown(context.Background())
+	require.Nil(t, err)
+	stopSync()
+}
+
+func TestHolderHandlersAdmin(t *testing.T) {
+	env.RequireZk(t)
+	// Sync idenPubOnChain every 2 seconds
+	stopSync := env.StartSync(2*time.Second, 10, 100)
+	defer stopSync()
+
+	dataApprove := randomBase64String(16)
+	dataReject := randomBase64String(16)
+	server := env.Serve(t, &mockupserver.Conf{
+		IP:                "127.0.0.1",
+		Port:              "1237",
+		TimeToAproveClaim: 1 * time.Second,
+		TimeToPublish:     2 * time.Second,
+		DefaultBehaviour:  mockupserver.BehaviourManual,
+	})
+	time.Sleep(1 * time.Second)
+	issuerUrl := "http://127.0.0.1:1237/"
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "holderAdminTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	ha := &testScenariosEventHandler{events: make(chan *Event, 16)}
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestHolderAdmin", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), ha)
+	require.Nil(t, err)
+
+	tickets := make(map[string]string)
+	for _, data := range []string{dataApprove, dataReject} {
+		ticket, err := id.RequestClaim(issuerUrl, data)
+		require.Nil(t, err)
+		tickets[ticket.Id] = data
+	}
+	// The manual requests stay pending until they are resolved with the admin endpoints
+	httpClient := NewHttpClient(issuerUrl)
+	listRequests := func(status string) []mockupserver.AdminRequest {
+		var requests []mockupserver.AdminRequest
+		require.Nil(t, httpClient.DoRequest(httpClient.NewRequest().Path("admin/claim/requests").
+			QueryStruct(&struct {
+				Status string `url:"status"`
+			}{Status: status}).Get(""), &requests))
+		return requests
+	}
+	adminPost := func(action string, requestID int) error {
+		return httpClient.DoRequest(httpClient.NewRequest().Path(
+			fmt.Sprintf("admin/claim/%v/%v", action, requestID)).Post(""), nil)
+	}
+	time.Sleep(2 * time.Second)
+	requestIDs := make(map[string]int)
+	for _, request := range listRequests("pending") {
+		requestIDs[request.Value] = request.Id
+	}
+	require.Equal(t, 2, len(requestIDs))
+	require.Nil(t, adminPost("approve", requestIDs[dataApprove]))
+	require.Nil(t, adminPost("reject", requestIDs[dataReject]))
+	// The requests that have already been resolved are not resolved again
+	for _, action := range []string{"approve", "reject"} {
+		for _, data := range []string{dataApprove, dataReject} {
+			err := adminPost(action, requestIDs[data])
+			var statusErr StatusError
+			require.True(t, errors.As(err, &statusErr), err)
+			require.Equal(t, http.StatusConflict, statusErr.StatusCode)
+		}
+	}
+	require.Equal(t, 1, len(listRequests("approved")))
+	require.Equal(t, 1, len(listRequests("rejected")))
+	require.Equal(t, 0, len(listRequests("pending")))
+
+	events := make(map[string]*Event)
+	for len(events) < len(tickets) {
+		select {
+		case ev := <-ha.events:
+			events[tickets[ev.TicketId]] = ev
+		case <-time.After(2 * time.Minute):
+			require.FailNow(t, "Events not received", "received %v of %v", len(events), len(tickets))
+		}
+	}
+	ev := events[dataReject]
+	require.Nil(t, ev.Err)
+	var evReject eventReqClaim
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &evReject))
+	require.Equal(t, "", evReject.CredID)
+	ev = events[dataApprove]
+	require.Nil(t, ev.Err)
+	var evApprove eventReqClaim
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &evApprove))
+	isSuccess, err := id.ProveClaim(issuerUrl, evApprove.CredID)
+	require.NoError(t, err)
+	require.True(t, isSuccess)
+
+	// Revoke the claim with the admin endpoints, the holder can't prove it once the
+	// issuer publishes the new state
+	require.Nil(t, adminPost("revoke", requestIDs[dataApprove]))
+	require.Error(t, adminPost("revoke", requestIDs[dataApprove]))
+	require.Error(t, adminPost("revoke", requestIDs[dataReject]))
+	for i := 0; ; i++ {
+		if isSuccess, _ := id.ProveClaim(issuerUrl, evApprove.CredID); !isSuccess {
+			break
+		}
+		require.Less(t, i, 30, "The revoked claim can still be proved")
+		time.Sleep(2 * time.Second)
+	}
+	approved := listRequests("approved")
+	require.Equal(t, 1, len(approved))
+	require.True(t, approved[0].Revoked)
+
+	id.Stop()
+	err = server.Shutdown(context.Background())
+	require.Nil(t, err)
+	stopSync()
+}
+
+func TestClaimRequestIndexValue(t *testing.T) {
+	env.RequireZk(t)
+	server := env.Serve(t, &mockupserver.Conf{
+		IP:               "127.0.0.1",
+		Port:             "1238",
+		TimeToPublish:    2 * time.Second,
+		DefaultBehaviour: mockupserver.BehaviourManual,
+		Issuers: []mockupserver.IssuerConf{{
+			Prefix:           "typed",
+			TimeToPublish:    2 * time.Second,
+			DefaultBehaviour: mockupserver.BehaviourManual,
+			ClaimType:        "Type.",
+		}},
+	})
+	time.Sleep(1 * time.Second)
+	issuerUrl := "http://127.0.0.1:1238/typed/"
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "holderIndexValueTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestClaimRequestIndexValue", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	// Request a claim whose index and value differ
+	req := auth.ReqClaimRequest{
+		ReqClaimRequest: issuerMsg.ReqClaimRequest{
+			Index:    "index",
+			Value:    "value",
+			HolderID: id.id.ID(),
+		},
+	}
+	req.Signature, err = id.sign(auth.DomainClaimRequest, auth.ClaimRequestPayload(&req.ReqClaimRequest))
+	require.Nil(t, err)
+	httpClient := NewHttpClient(issuerUrl)
+	var res issuerMsg.ResClaimRequest
+	require.Nil(t, httpClient.DoRequest(httpClient.NewRequest().Path("claim/request").
+		BodyJSON(&req).Post(""), &res))
+	require.Nil(t, httpClient.DoRequest(httpClient.NewRequest().Path(
+		fmt.Sprintf("admin/claim/approve/%v", res.Id)).Post(""), nil))
+	var status issuerMsg.ResClaimStatus
+	require.Nil(t, httpClient.DoRequest(httpClient.NewRequest().Path(
+		fmt.Sprintf("claim/status/%v", res.Id)).Get(""), &status))
+	require.Equal(t, issuerMsg.RequestStatusApproved, status.Status)
+
+	// The index slot has the claim type and the index, and the value slot the value
+	claim := claims.NewClaimOtherIdenFromEntry(status.Claim)
+	var indexSlot [claims.IndexSubjectSlotLen]byte
+	copy(indexSlot[152/8:], "Type.index")
+	var valueSlot [claims.ValueSlotLen]byte
+	copy(valueSlot[216/8:], "value")
+	require.Equal(t, indexSlot, claim.IndexSlot)
+	require.Equal(t, valueSlot, claim.ValueSlot)
+
+	err = server.Shutdown(context.Background())
+	require.Nil(t, err)
+}
+
+func TestVerifierTrustedIssuersAndMaxAge(t *testing.T) {
+	env.RequireZk(t)
+	// The verifiers check the age of the credentials against the real time, so the local
+	// smart contract follows it
+	timeBlock.SetTime(time.Now().Unix())
+	defer env.StartSync(1*time.Second, 1, 1)()
+
+	maxAge := 3 * time.Second
+	server := env.Serve(t, &mockupserver.Conf{
+		IP:                "127.0.0.1",
+		Port:              "1236",
+		TimeToAproveClaim: 1 * time.Second,
+		TimeToPublish:     2 * time.Second,
+		Issuers: []mockupserver.IssuerConf{
+			{Prefix: "issuerA", TimeToAproveClaim: 1 * time.Second, TimeToPublish: 2 * time.Second},
+			{Prefix: "issuerB", TimeToAproveClaim: 1 * time.Second, TimeToPublish: 2 * time.Second},
+		},
+		Verifiers: []mockupserver.VerifierConf{
+			{Prefix: "strict", MaxAge: maxAge, Issuers: []string{"issuerA"}},
+			{Prefix: "lenient", Issuers: []string{"issuerA"}},
+		},
+	})
+	defer server.Close()
+	time.Sleep(1 * time.Second)
+	baseUrl := "http://127.0.0.1:1236/"
+	issuerAUrl, issuerBUrl := baseUrl+"issuerA/", baseUrl+"issuerB/"
+	strictUrl, lenientUrl := baseUrl+"strict/", baseUrl+"lenient/"
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "verifierTrustTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	ha := &testScenariosEventHandler{events: make(chan *Event, 16)}
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestVerifierTrust", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), ha)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	requestCredential := func(issuerUrl string) string {
+		ticket, err := id.RequestClaim(issuerUrl, randomBase64String(16))
+		require.Nil(t, err)
+		select {
+		case ev := <-ha.events:
+			require.Equal(t, ticket.Id, ev.TicketId)
+			require.Nil(t, ev.Err)
+			var evClaim eventReqClaim
+			require.Nil(t, json.Unmarshal([]byte(ev.Data), &evClaim))
+			require.NotEqual(t, "", evClaim.CredID)
+			return evClaim.CredID
+		case <-time.After(2 * time.Minute):
+			require.FailNow(t, "Credential not received")
+		}
+		return ""
+	}
+	credA := requestCredential(issuerAUrl)
+	credB := requestCredential(issuerBUrl)
+
+	// The credentials of an issuer that is not trusted are rejected, even if they are valid
+	ok, err := id.ProveClaim(strictUrl, credB)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "untrusted issuer")
+	require.False(t, ok)
+	ok, err = id.ProveClaim(baseUrl, credB)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = id.ProveClaim(strictUrl, credA)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// Once the issuer publishes a new state, a credential of the previous state is only
+	// accepted during MaxAge
+	credValA, err := id.getCredentialValidity(credA)
+	require.Nil(t, err)
+	requestCredential(issuerAUrl)
+	time.Sleep(time.Until(time.Unix(credValA.IdenStateData.BlockTs, 0).Add(maxAge + time.Second)))
+	verify := func(verifierUrl string) error {
+		httpClient := NewHttpClient(verifierUrl)
+		return httpClient.DoRequest(httpClient.NewRequest().Path("verify").Post("").
+			BodyJSON(verifierMsg.ReqVerify{CredentialValidity: credValA}), nil)
+	}
+	err = verify(strictUrl)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Outdated")
+	require.Nil(t, verify(lenientUrl))
+	// A credential of the last state is accepted
+	ok, err = id.ProveClaim(strictUrl, credA)
+	require.Nil(t, err)
+	require.True(t, ok)
+}
+
 func randomBase64String(l int) string {
 	buff := make([]byte, int(math.Round(float64(l)/float64(1.33333333333))))
 	_, err := rand.Read(buff)
@@ -174,16 +622,9 @@ func (ha *testStressIdentityEventHandler) Send(ev *Event) {
 }
 
 func TestStressIdentity(t *testing.T) {
-	// Sync idenPubOnChain every 2 seconds
-	go func() {
-		for {
-			log.Info("idenPubOnChain.Sync()")
-			timeBlock.AddTime(10)
-			timeBlock.AddBlock(1)
-			idenPubOnChain.Sync()
-			time.Sleep(700 * time.Millisecond)
-		}
-	}()
+	env.RequireZk(t)
+	// Sync idenPubOnChain every 700 milliseconds
+	defer env.StartSync(700*time.Millisecond, 1, 10)()
 
 	n := 16
 	m := 4
@@ -206,18 +647,14 @@ func TestStressIdentity(t *testing.T) {
 	log.WithField("n", n).WithField("m", m).Info("-- Stress Identity")
 
 	// Start mockup servers
-	servers := make([]*http.Server, n)
+	servers := make([]*mockupserver.Server, n)
 	for i := 0; i < n; i++ {
-		servers[i] = mockupserver.Serve(t, &mockupserver.Conf{
+		servers[i] = env.Serve(t, &mockupserver.Conf{
 			IP:                "127.0.0.1",
 			Port:              fmt.Sprintf("9%03d", i),
 			TimeToAproveClaim: 1 * time.Second,
 			TimeToPublish:     500 * time.Millisecond,
-		},
-			idenPubOnChain,
-			zkFilesIdenState,
-			zkFilesCredential,
-		)
+		})
 		time.Sleep(200 * time.Millisecond)
 	}
 	sharedDir, err := ioutil.TempDir("", "shared")
@@ -0,0 +1,156 @@
+package iden3mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/iden3/go-iden3-core/components/idenpubonchain"
+	"github.com/iden3/go-iden3-core/components/verifier"
+	"github.com/iden3/go-iden3-core/core/proof"
+	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrZkArtifactsMissing is returned when the verification key of a ZK proof is not in the
+// shared store and there is no url to download it from.
+var ErrZkArtifactsMissing = errors.New("The ZK artifacts are not in the shared store and there is no url to download them")
+
+// VerifierResult is the outcome of a verification done by the Verifier.
+// When Success is false, Error contains the reason why the proof was not accepted.
+type VerifierResult struct {
+	Success         bool
+	IssuerID        string
+	Claim           string
+	IdenStateBlockN int64
+	Error           string
+}
+
+// Verifier checks the proofs generated by other identities without the need of
+// a verifier server, so a device can check the credentials presented by another device.
+type Verifier struct {
+	verif           *verifier.Verifier
+	sharedStorePath string
+	freshness       time.Duration
+	// zkArtifactsUrl is the base url from where the verification keys of the ZK proofs in
+	// presentations are downloaded, if they are not in the shared store
+	zkArtifactsUrl string
+}
+
+// NewVerifier creates a verifier that accepts proofs of identity states published
+// during the last freshnessSeconds (or the last published one).
+// this funciton is mapped as a constructor in Java.
+// NOTE: The ZK artifacts used to verify ZK proofs are stored in the sharedStorePath.
+func NewVerifier(web3Url, sharedStorePath string, freshnessSeconds int) (*Verifier, error) {
+	idenPubOnChain, err := loadIdenPubOnChain(web3Url)
+	if err != nil {
+		return nil, err
+	}
+	return newVerifier(idenPubOnChain, sharedStorePath, freshnessSeconds)
+}
+
+func newVerifier(idenPubOnChain idenpubonchain.IdenPubOnChainer, sharedStorePath string, freshnessSeconds int) (*Verifier, error) {
+	if freshnessSeconds <= 0 {
+		return nil, errors.New("The freshness window must be a positive amount of seconds")
+	}
+	return &Verifier{
+		verif:           verifier.New(idenPubOnChain),
+		sharedStorePath: sharedStorePath,
+		freshness:       time.Duration(freshnessSeconds) * time.Second,
+	}, nil
+}
+
+// SetZkArtifactsUrl sets the base url (of a trusted verifier server) from where the verification
+// keys needed to verify the ZK proofs of presentations are downloaded, if they are not in the shared
+// store. Without it, the ZK artifacts must be already in the shared store.
+func (v *Verifier) SetZkArtifactsUrl(baseUrl string) error {
+	baseUrl, err := validateBaseUrl(baseUrl)
+	if err != nil {
+		return err
+	}
+	v.zkArtifactsUrl = baseUrl
+	return nil
+}
+
+func newVerifierResultErr(err error) *VerifierResult {
+	log.WithError(err).Info("Proof rejected by the verifier")
+	return &VerifierResult{
+		Success: false,
+		Error:   err.Error(),
+	}
+}
+
+// VerifyCredentialValidity verifies a credential validity encoded in JSON,
+// as the ones built by the Identity when calling ProveClaim.
+func (v *Verifier) VerifyCredentialValidity(credValidJSON string) *VerifierResult {
+	var credVal proof.CredentialValidity
+	if err := json.Unmarshal([]byte(credValidJSON), &credVal); err != nil {
+		return newVerifierResultErr(err)
+	}
+	return v.verifyCredentialValidity(&credVal)
+}
+
+func (v *Verifier) verifyCredentialValidity(credVal *proof.CredentialValidity) *VerifierResult {
+	if credVal.CredentialExistence.Id == nil || credVal.CredentialExistence.Claim == nil ||
+		credVal.CredentialExistence.MtpClaim == nil || credVal.MtpNotNonce == nil ||
+		credVal.IdenStateData.IdenState == nil {
+		return newVerifierResultErr(errors.New("Incomplete credential validity"))
+	}
+	if err := v.verif.VerifyCredentialValidity(credVal, v.freshness); err != nil {
+		return newVerifierResultErr(err)
+	}
+	claimJSON, err := claim2JSON(credVal.CredentialExistence.Claim)
+	if err != nil {
+		return newVerifierResultErr(err)
+	}
+	return &VerifierResult{
+		Success:         true,
+		IssuerID:        credVal.CredentialExistence.Id.String(),
+		Claim:           string(claimJSON),
+		IdenStateBlockN: int64(credVal.IdenStateData.BlockN),
+	}
+}
+
+// VerifyZkProofCredential verifies a zero knowledge proof of a credential encoded in JSON,
+// with the same format used by the Identity when calling ProveClaimZK.
+// The verification key is loaded from the shared store, and downloaded from baseUrl if missing.
+func (v *Verifier) VerifyZkProofCredential(zkProofJSON, baseUrl string) *VerifierResult {
+	var zkp verifierMsg.ReqVerifyZkp
+	if err := json.Unmarshal([]byte(zkProofJSON), &zkp); err != nil {
+		return newVerifierResultErr(err)
+	}
+	return v.verifyZkProofCredential(&zkp, baseUrl)
+}
+
+func (v *Verifier) verifyZkProofCredential(zkp *verifierMsg.ReqVerifyZkp, baseUrl string) *VerifierResult {
+	if zkp.ZkProof == nil || zkp.IssuerID == nil || len(zkp.PubSignals) == 0 {
+		return newVerifierResultErr(errors.New("Incomplete zero knowledge proof"))
+	}
+	zkFiles, err := newClaimDemoZkFiles(baseUrl, v.sharedStorePath)
+	if err != nil {
+		return newVerifierResultErr(err)
+	}
+	if baseUrl == "" {
+		// Without url, the verification key can't be downloaded
+		if _, err := os.Stat(path.Join(zkFiles.Path, zkVerificationKeyFile)); err != nil {
+			return newVerifierResultErr(ErrZkArtifactsMissing)
+		}
+	}
+	if err := v.verif.VerifyZkProofCredential(
+		zkp.ZkProof,
+		zkp.PubSignals,
+		zkp.IssuerID,
+		zkp.IdenStateBlockN,
+		zkFiles,
+		v.freshness,
+	); err != nil {
+		return newVerifierResultErr(err)
+	}
+	return &VerifierResult{
+		Success:         true,
+		IssuerID:        zkp.IssuerID.String(),
+		IdenStateBlockN: int64(zkp.IdenStateBlockN),
+	}
+}
@@ -0,0 +1,33 @@
+package iden3mobile
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifierInvalidInputs(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	// The freshness window must be positive
+	_, err = newVerifier(idenPubOnChain, sharedDir, 0)
+	require.Error(t, err)
+	v, err := newVerifier(idenPubOnChain, sharedDir, 60)
+	require.Nil(t, err)
+	// Malformed JSON
+	res := v.VerifyCredentialValidity("not a credential")
+	require.False(t, res.Success)
+	require.NotEqual(t, "", res.Error)
+	res = v.VerifyZkProofCredential("not a proof", c.VerifierUrl)
+	require.False(t, res.Success)
+	require.NotEqual(t, "", res.Error)
+	// Incomplete proofs
+	res = v.VerifyCredentialValidity("{}")
+	require.False(t, res.Success)
+	require.Equal(t, "Incomplete credential validity", res.Error)
+	res = v.VerifyZkProofCredential("{}", c.VerifierUrl)
+	require.False(t, res.Success)
+	require.Equal(t, "Incomplete zero knowledge proof", res.Error)
+}
@@ -0,0 +1,569 @@
+package iden3mobile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/iden3/iden3-mobile/go/relayer"
+	log "github.com/sirupsen/logrus"
+)
+
+// The ZK artifacts (proving key, verification key and witness calculator) of the circuits are
+// stored in the shared store, at ZKArtifacts/<circuit>/<version>, where the version is derived
+// from the hashes of the artifacts, so that the artifacts of different versions of a circuit can
+// be kept at the same time. The artifacts are downloaded the first time they are needed, or
+// before with PrefetchZkArtifacts, and their hashes are checked every time they are loaded.
+
+const (
+	// ZkCircuitClaimDemo is the circuit used to prove claims with ProveClaimZK
+	ZkCircuitClaimDemo = claimDemoProofName
+	// ZkCircuitIdState is the circuit used to publish the state of the identities created with a relayer
+	ZkCircuitIdState = idStateProofName
+	// zkArtifactsLastUsedFile is the file whose modification time is the last time the artifacts of
+	// a circuit version were used
+	zkArtifactsLastUsedFile = "lastused"
+	// zkArtifactsTmpSuffix is the suffix of the artifacts being downloaded
+	zkArtifactsTmpSuffix = ".download"
+	// zkVerificationKeyFile is the name of the verification key used by zkutils.ZkFiles
+	zkVerificationKeyFile = "verification_key.json"
+)
+
+var ErrZkArtifactsInUse = errors.New("The ZK artifacts are in use")
+
+// zkCircuit describes the ZK artifacts of a circuit.
+type zkCircuit struct {
+	name string
+	// urlPath is the path of the artifacts relative to the url of the verifier or relayer that serves them
+	urlPath string
+	hashes  zkutils.ZkFilesHashes
+}
+
+var zkCircuits = map[string]*zkCircuit{
+	ZkCircuitClaimDemo: {
+		name:    ZkCircuitClaimDemo,
+		urlPath: "credentialDemo/artifacts",
+		hashes: zkutils.ZkFilesHashes{
+			ProvingKey:      "bdefc89d07d1dfab75c43f09aedb9da876496c5c3967383337482e4c5ae4f7d3",
+			VerificationKey: "12a730890e85e33d8bf0f2e54db41dcff875c2dc49011d7e2a283185f47ac0de",
+			WitnessCalcWASM: "6b3c28c4842e04129674eb71dc84d76dd8b290c84987929d54d890b7b8bed211",
+		},
+	},
+	ZkCircuitIdState: {
+		name:    ZkCircuitIdState,
+		urlPath: relayer.PathIdStateArtifacts,
+		hashes: zkutils.ZkFilesHashes{
+			ProvingKey:      "37b6b3addd52faf9357f1496312e6a86af4f5c41c557cda9931468809d32c03c",
+			VerificationKey: "473952ff80aef85403005eb12d1e78a3f66b1cc11e7bd55d6bfe94e0b5577640",
+			WitnessCalcWASM: "8eafd9314c4d2664a23bf98a4f42cd0c29984960ae3544747ba5fbd60905c41f",
+		},
+	},
+}
+
+func getZkCircuit(name string) (*zkCircuit, error) {
+	circuit, ok := zkCircuits[name]
+	if !ok {
+		if circuit, err := getZkQueryCircuit(name); err == nil {
+			return circuit, nil
+		}
+		return nil, fmt.Errorf("Unknown ZK circuit: %v", name)
+	}
+	return circuit, nil
+}
+
+// version returns the version of the circuit, derived from the hashes of its artifacts
+func (c *zkCircuit) version() string {
+	h := sha256.Sum256([]byte(c.hashes.ProvingKey + c.hashes.VerificationKey + c.hashes.WitnessCalcWASM))
+	return hex.EncodeToString(h[:8])
+}
+
+type zkArtifactFile struct {
+	basename string
+	hash     string
+}
+
+// files returns the artifacts of the circuit, with the names used by zkutils.ZkFiles
+func (c *zkCircuit) files() []zkArtifactFile {
+	return []zkArtifactFile{
+		{fmt.Sprintf("proving_key.%v", zkutils.ProvingKeyFormatGoBin), c.hashes.ProvingKey},
+		{zkVerificationKeyFile, c.hashes.VerificationKey},
+		{"circuit.wasm", c.hashes.WitnessCalcWASM},
+	}
+}
+
+// ZkArtifactsProgress is the progress of the download of the ZK artifacts of a circuit.
+type ZkArtifactsProgress struct {
+	Circuit string
+	Version string
+	// Downloading is true while the artifacts are being downloaded or checked
+	Downloading bool
+	// Complete is true when all the artifacts are stored
+	Complete bool
+	// Bytes is the size of the artifacts already stored, out of TotalBytes.
+	// TotalBytes is 0 until the size of the artifacts is known.
+	Bytes      int64
+	TotalBytes int64
+}
+
+// zkDownload is a download of the ZK artifacts of a circuit version, shared by all the
+// ZkArtifacts of the same shared store.
+type zkDownload struct {
+	progress ZkArtifactsProgress
+	done     chan struct{}
+	err      error
+}
+
+var zkDownloads = struct {
+	sync.Mutex
+	// downloads are the running and finished downloads by directory
+	downloads map[string]*zkDownload
+	// pinned are the directories of the artifacts used by loaded identities, that can't be removed,
+	// with the number of identities using them
+	pinned map[string]int
+}{
+	downloads: make(map[string]*zkDownload),
+	pinned:    make(map[string]int),
+}
+
+// ZkArtifacts manages the ZK artifacts stored in a shared store.
+type ZkArtifacts struct {
+	path string
+}
+
+// NewZkArtifacts creates a manager of the ZK artifacts stored in sharedStorePath
+// this funciton is mapped as a constructor in Java.
+func NewZkArtifacts(sharedStorePath string) *ZkArtifacts {
+	return &ZkArtifacts{path: path.Join(sharedStorePath, folderZKArtifacts)}
+}
+
+func (za *ZkArtifacts) dir(circuit, version string) string {
+	return path.Join(za.path, circuit, version)
+}
+
+// zkFiles returns the ZK artifacts of the current version of circuit, that are downloaded from
+// url the first time they are needed. If pin is true, the artifacts can't be removed until they
+// are released with unpinZkArtifacts.
+func (za *ZkArtifacts) zkFiles(circuit *zkCircuit, url string, pin bool) (zkFiles *zkutils.ZkFiles, err error) {
+	dir := za.dir(circuit.name, circuit.version())
+	// Pin the artifacts before creating their directory so that they aren't evicted meanwhile
+	if pin {
+		zkDownloads.Lock()
+		zkDownloads.pinned[dir]++
+		zkDownloads.Unlock()
+		defer func() {
+			if err != nil {
+				unpinZkArtifacts(dir)
+			}
+		}()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Move the artifacts stored before the circuits had versions
+	for _, file := range circuit.files() {
+		oldPath := path.Join(za.path, circuit.name, file.basename)
+		if _, err := os.Stat(oldPath); err == nil {
+			if err := os.Rename(oldPath, path.Join(dir, file.basename)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := touch(path.Join(dir, zkArtifactsLastUsedFile)); err != nil {
+		return nil, err
+	}
+	return zkutils.NewZkFiles(url, dir, zkutils.ProvingKeyFormatGoBin, circuit.hashes, false), nil
+}
+
+// unpinZkArtifacts releases the artifacts in dir pinned by zkFiles
+func unpinZkArtifacts(dir string) {
+	zkDownloads.Lock()
+	defer zkDownloads.Unlock()
+	if zkDownloads.pinned[dir] > 1 {
+		zkDownloads.pinned[dir]--
+	} else {
+		delete(zkDownloads.pinned, dir)
+	}
+}
+
+// fetch returns the ZK artifacts of the current version of circuit, downloading the missing ones
+// from url or the mirrors of the circuit. The artifacts are pinned so that they can't be removed
+// while they are used: the caller must release them with unpinZkArtifacts(zkFiles.Path).
+func (za *ZkArtifacts) fetch(circuit *zkCircuit, url string) (*zkutils.ZkFiles, error) {
+	zkFiles, err := za.zkFiles(circuit, url, true)
+	if err != nil {
+		return nil, err
+	}
+	progress, err := za.Progress(circuit.name)
+	if err != nil {
+		unpinZkArtifacts(zkFiles.Path)
+		return nil, err
+	}
+	if progress.Complete && !progress.Downloading {
+		return zkFiles, nil
+	}
+	dl := za.download(circuit, url, true)
+	<-dl.done
+	if dl.err != nil {
+		unpinZkArtifacts(zkFiles.Path)
+		return nil, dl.err
+	}
+	return zkFiles, nil
+}
+
+// touch creates the file or updates its modification time
+func touch(filename string) error {
+	now := time.Now()
+	if err := os.Chtimes(filename, now, now); os.IsNotExist(err) {
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	} else {
+		return err
+	}
+}
+
+// download starts the download of the ZK artifacts of the current version of circuit from url,
+// or returns the last one. If the last one has finished and restart is true, the download is
+// started again. The artifacts already stored are checked and downloaded again if their hash doesn't
+// match.
+func (za *ZkArtifacts) download(circuit *zkCircuit, url string, restart bool) *zkDownload {
+	version := circuit.version()
+	dir := za.dir(circuit.name, version)
+	zkDownloads.Lock()
+	defer zkDownloads.Unlock()
+	if dl, ok := zkDownloads.downloads[dir]; ok && !(restart && dl.finished()) {
+		return dl
+	}
+	dl := &zkDownload{
+		progress: ZkArtifactsProgress{
+			Circuit:     circuit.name,
+			Version:     version,
+			Downloading: true,
+		},
+		done: make(chan struct{}),
+	}
+	zkDownloads.downloads[dir] = dl
+	go func() {
+		err := za.downloadFiles(circuit, dir, url, dl)
+		zkDownloads.Lock()
+		defer zkDownloads.Unlock()
+		dl.err = err
+		dl.progress.Downloading = false
+		if err != nil {
+			log.WithError(err).WithField("circuit", circuit.name).Error("Error downloading ZK artifacts")
+		} else {
+			dl.progress.Complete = true
+		}
+		close(dl.done)
+	}()
+	return dl
+}
+
+// finished returns true if the download has finished
+func (dl *zkDownload) finished() bool {
+	select {
+	case <-dl.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// failed returns true if the download finished with an error
+func (dl *zkDownload) failed() bool {
+	return dl.finished() && dl.err != nil
+}
+
+// addProgress adds bytes and totalBytes to the progress of dl
+func (dl *zkDownload) addProgress(bytes, totalBytes int64) {
+	zkDownloads.Lock()
+	defer zkDownloads.Unlock()
+	dl.progress.Bytes += bytes
+	dl.progress.TotalBytes += totalBytes
+}
+
+// Progress returns the progress of the download of the ZK artifacts of the current version of
+// the circuit.
+func (za *ZkArtifacts) Progress(circuit string) (*ZkArtifactsProgress, error) {
+	c, err := getZkCircuit(circuit)
+	if err != nil {
+		return nil, err
+	}
+	version := c.version()
+	dir := za.dir(circuit, version)
+	zkDownloads.Lock()
+	dl, ok := zkDownloads.downloads[dir]
+	if ok && !dl.failed() {
+		progress := dl.progress
+		zkDownloads.Unlock()
+		return &progress, nil
+	}
+	zkDownloads.Unlock()
+	progress := &ZkArtifactsProgress{Circuit: circuit, Version: version, Complete: true}
+	for _, file := range c.files() {
+		info, err := os.Stat(path.Join(dir, file.basename))
+		if os.IsNotExist(err) {
+			progress.Complete = false
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		progress.Bytes += info.Size()
+	}
+	if progress.Complete {
+		progress.TotalBytes = progress.Bytes
+	}
+	return progress, nil
+}
+
+// ZkArtifactsInfo describes the stored ZK artifacts of a circuit version.
+type ZkArtifactsInfo struct {
+	Circuit string
+	Version string
+	// Current is true if the version is the one used by this library
+	Current bool
+	// Complete is true if all the artifacts are stored
+	Complete bool
+	// Size is the size in bytes of the stored artifacts
+	Size int64
+	// LastUsed is the last time (unix seconds) the artifacts were used
+	LastUsed int64
+	// InUse is true if the artifacts are being downloaded or used by a loaded identity
+	InUse bool
+}
+
+type ZkArtifactsInfoArray struct {
+	array []*ZkArtifactsInfo
+}
+
+func (za *ZkArtifactsInfoArray) Len() int {
+	return len(za.array)
+}
+
+func (za *ZkArtifactsInfoArray) Get(i int) *ZkArtifactsInfo {
+	return za.array[i]
+}
+
+// list returns the stored ZK artifacts, sorted by circuit and version
+func (za *ZkArtifacts) list() ([]*ZkArtifactsInfo, error) {
+	circuitDirs, err := ioutil.ReadDir(za.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	zkDownloads.Lock()
+	defer zkDownloads.Unlock()
+	var infos []*ZkArtifactsInfo
+	for _, circuitDir := range circuitDirs {
+		if !circuitDir.IsDir() {
+			continue
+		}
+		versionDirs, err := ioutil.ReadDir(path.Join(za.path, circuitDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, versionDir := range versionDirs {
+			if !versionDir.IsDir() {
+				continue
+			}
+			dir := za.dir(circuitDir.Name(), versionDir.Name())
+			info := &ZkArtifactsInfo{
+				Circuit:  circuitDir.Name(),
+				Version:  versionDir.Name(),
+				LastUsed: versionDir.ModTime().Unix(),
+				InUse:    zkDownloads.pinned[dir] > 0,
+			}
+			if dl, ok := zkDownloads.downloads[dir]; ok && dl.progress.Downloading {
+				info.InUse = true
+			}
+			circuit, err := getZkCircuit(info.Circuit)
+			known := err == nil
+			info.Current = known && circuit.version() == info.Version
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			stored := make(map[string]bool)
+			for _, file := range files {
+				info.Size += file.Size()
+				stored[file.Name()] = true
+				if file.Name() == zkArtifactsLastUsedFile {
+					info.LastUsed = file.ModTime().Unix()
+				}
+			}
+			info.Complete = known
+			if known {
+				for _, file := range circuit.files() {
+					info.Complete = info.Complete && stored[file.basename]
+				}
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// List returns the ZK artifacts stored in the shared store.
+func (za *ZkArtifacts) List() (*ZkArtifactsInfoArray, error) {
+	infos, err := za.list()
+	if err != nil {
+		return nil, err
+	}
+	return &ZkArtifactsInfoArray{array: infos}, nil
+}
+
+// Usage returns the disk usage in bytes of the ZK artifacts stored in the shared store.
+func (za *ZkArtifacts) Usage() (int64, error) {
+	infos, err := za.list()
+	if err != nil {
+		return 0, err
+	}
+	var usage int64
+	for _, info := range infos {
+		usage += info.Size
+	}
+	return usage, nil
+}
+
+// Delete removes the ZK artifacts of a circuit version. The artifacts in use can't be removed.
+func (za *ZkArtifacts) Delete(circuit, version string) error {
+	dir := za.dir(circuit, version)
+	if path.Dir(path.Dir(dir)) != za.path {
+		return fmt.Errorf("Invalid ZK circuit %v or version %v", circuit, version)
+	}
+	zkDownloads.Lock()
+	defer zkDownloads.Unlock()
+	if dl, ok := zkDownloads.downloads[dir]; zkDownloads.pinned[dir] > 0 || (ok && dl.progress.Downloading) {
+		return ErrZkArtifactsInUse
+	}
+	delete(zkDownloads.downloads, dir)
+	return os.RemoveAll(dir)
+}
+
+// Evict removes ZK artifacts not in use until the disk usage is at most budgetBytes. The
+// artifacts of versions not used by this library are removed first, and then the least recently
+// used. Evicted artifacts are downloaded again the next time they are needed.
+func (za *ZkArtifacts) Evict(budgetBytes int64) error {
+	infos, err := za.list()
+	if err != nil {
+		return err
+	}
+	var usage int64
+	for _, info := range infos {
+		usage += info.Size
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Current != infos[j].Current {
+			return !infos[i].Current
+		}
+		return infos[i].LastUsed < infos[j].LastUsed
+	})
+	for _, info := range infos {
+		if usage <= budgetBytes {
+			break
+		}
+		if info.InUse {
+			continue
+		}
+		if err := za.Delete(info.Circuit, info.Version); err == ErrZkArtifactsInUse {
+			continue
+		} else if err != nil {
+			return err
+		}
+		log.WithField("circuit", info.Circuit).WithField("version", info.Version).Info("ZK artifacts evicted")
+		usage -= info.Size
+	}
+	if usage > budgetBytes {
+		return fmt.Errorf("The ZK artifacts in use take %v bytes, more than the budget of %v bytes", usage, budgetBytes)
+	}
+	return nil
+}
+
+type zkArtifactsHandler struct {
+	Circuit string
+	Url     string
+}
+
+type eventZkArtifacts struct {
+	Circuit string
+	Version string
+}
+
+func (h *zkArtifactsHandler) isDone(id *Identity) (bool, string, error) {
+	circuit, err := getZkCircuit(h.Circuit)
+	if err != nil {
+		return true, "{}", err
+	}
+	dl := id.ZkArtifacts.download(circuit, h.Url, false)
+	select {
+	case <-dl.done:
+	default:
+		return false, "", nil
+	}
+	if dl.err != nil {
+		return true, "{}", dl.err
+	}
+	j, err := json.Marshal(eventZkArtifacts{
+		Circuit: circuit.name,
+		Version: circuit.version(),
+	})
+	if err != nil {
+		return true, "{}", err
+	}
+	return true, string(j), nil
+}
+
+// PrefetchZkArtifacts downloads the ZK artifacts of the circuit in the background, from the
+// verifier (for ZkCircuitClaimDemo) or relayer (for ZkCircuitIdState) at baseUrl. The artifacts
+// already stored are checked, and downloaded again if they are corrupted. The progress of the
+// download can be checked with ZkArtifacts.Progress.
+// This function will eventually trigger an event,
+// the returned ticket can be used to reference the event
+func (i *Identity) PrefetchZkArtifacts(circuit, baseUrl string) (*Ticket, error) {
+	if err := i.begin(); err != nil {
+		return nil, err
+	}
+	defer i.end()
+	c, err := getZkCircuit(circuit)
+	if err != nil {
+		return nil, err
+	}
+	baseUrl, err = validateBaseUrl(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	url := baseUrl + c.urlPath
+	i.ZkArtifacts.download(c, url, true)
+	t := &Ticket{
+		Id:     uuid.New().String(),
+		Type:   TicketTypeZkArtifacts,
+		Status: TicketStatusPending,
+		handler: &zkArtifactsHandler{
+			Circuit: circuit,
+			Url:     url,
+		},
+	}
+	return t, i.Tickets.Add([]Ticket{*t})
+}
+
+type CallbackPrefetchZkArtifacts interface {
+	Fn(*Ticket, error)
+}
+
+// PrefetchZkArtifactsWithCb is like PrefetchZkArtifacts but non-blocking
+func (i *Identity) PrefetchZkArtifactsWithCb(circuit, baseUrl string, c CallbackPrefetchZkArtifacts) {
+	i.async(func() { c.Fn(i.PrefetchZkArtifacts(circuit, baseUrl)) })
+}
@@ -0,0 +1,243 @@
+package iden3mobile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/iden3/iden3-mobile/go/relayer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZkArtifacts(t *testing.T) {
+	// Serve the artifacts of a fake circuit
+	artifactsDir, err := ioutil.TempDir("", "zkArtifactsServer")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, artifactsDir)
+	hash := func(b []byte) string {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+	pk, vk, wasm := []byte("proving key"), []byte("verification key"), []byte("wasm")
+	circuit := &zkCircuit{
+		name:    "fakeCircuit",
+		urlPath: "fake/artifacts",
+		hashes: zkutils.ZkFilesHashes{
+			ProvingKey:      hash(pk),
+			VerificationKey: hash(vk),
+			WitnessCalcWASM: hash(wasm),
+		},
+	}
+	zkCircuits[circuit.name] = circuit
+	defer delete(zkCircuits, circuit.name)
+	for i, content := range [][]byte{pk, vk, wasm} {
+		require.Nil(t, ioutil.WriteFile(path.Join(artifactsDir, circuit.files()[i].basename), content, 0600))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/fake/artifacts/", http.StripPrefix("/fake/artifacts/", http.FileServer(http.Dir(artifactsDir))))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "zkArtifactsTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	ha := &testScenariosEventHandler{events: make(chan *Event, 16)}
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestZkArtifacts", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), ha)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	waitEvent := func(ticket *Ticket) *Event {
+		select {
+		case ev := <-ha.events:
+			require.Equal(t, ticket.Id, ev.TicketId)
+			return ev
+		case <-time.After(30 * time.Second):
+			require.FailNow(t, "Timeout waiting for the ZK artifacts event")
+			return nil
+		}
+	}
+
+	// Unknown circuit
+	_, err = id.PrefetchZkArtifacts("unknown", server.URL)
+	require.Error(t, err)
+
+	// Prefetch the artifacts
+	progress, err := id.ZkArtifacts.Progress(circuit.name)
+	require.Nil(t, err)
+	require.False(t, progress.Complete)
+	ticket, err := id.PrefetchZkArtifacts(circuit.name, server.URL)
+	require.Nil(t, err)
+	ev := waitEvent(ticket)
+	require.Nil(t, ev.Err)
+	var data eventZkArtifacts
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &data))
+	require.Equal(t, eventZkArtifacts{Circuit: circuit.name, Version: circuit.version()}, data)
+	progress, err = id.ZkArtifacts.Progress(circuit.name)
+	require.Nil(t, err)
+	size := int64(len(pk) + len(vk) + len(wasm))
+	require.Equal(t, ZkArtifactsProgress{
+		Circuit:    circuit.name,
+		Version:    circuit.version(),
+		Complete:   true,
+		Bytes:      size,
+		TotalBytes: size,
+	}, *progress)
+
+	// The loaded artifacts are checked
+	zkFiles, err := NewZkArtifacts(sharedDir).zkFiles(circuit, server.URL+"/"+circuit.urlPath, false)
+	require.Nil(t, err)
+	wasm2, err := zkFiles.WitnessCalcWASM()
+	require.Nil(t, err)
+	require.Equal(t, wasm, wasm2)
+
+	// Corrupted artifacts are downloaded again
+	vkPath := path.Join(id.ZkArtifacts.dir(circuit.name, circuit.version()), circuit.files()[1].basename)
+	require.Nil(t, ioutil.WriteFile(vkPath, []byte("corrupted"), 0600))
+	ticket, err = id.PrefetchZkArtifacts(circuit.name, server.URL)
+	require.Nil(t, err)
+	require.Nil(t, waitEvent(ticket).Err)
+	vk2, err := ioutil.ReadFile(vkPath)
+	require.Nil(t, err)
+	require.Equal(t, vk, vk2)
+
+	// A failed download is reported in the ticket event
+	require.Nil(t, os.Remove(path.Join(artifactsDir, circuit.files()[2].basename)))
+	require.Nil(t, id.ZkArtifacts.Delete(circuit.name, circuit.version()))
+	ticket, err = id.PrefetchZkArtifacts(circuit.name, server.URL)
+	require.Nil(t, err)
+	require.Error(t, waitEvent(ticket).Err)
+	require.Nil(t, ioutil.WriteFile(path.Join(artifactsDir, circuit.files()[2].basename), wasm, 0600))
+	ticket, err = id.PrefetchZkArtifacts(circuit.name, server.URL)
+	require.Nil(t, err)
+	require.Nil(t, waitEvent(ticket).Err)
+
+	// List the artifacts, with an old version of the circuit
+	oldVersionDir := id.ZkArtifacts.dir(circuit.name, "oldversion")
+	require.Nil(t, os.MkdirAll(oldVersionDir, 0700))
+	require.Nil(t, ioutil.WriteFile(path.Join(oldVersionDir, circuit.files()[0].basename), []byte("old"), 0600))
+	list, err := id.ZkArtifacts.List()
+	require.Nil(t, err)
+	infos := make(map[string]*ZkArtifactsInfo)
+	for i := 0; i < list.Len(); i++ {
+		infos[list.Get(i).Version] = list.Get(i)
+	}
+	require.True(t, infos[circuit.version()].Current)
+	require.True(t, infos[circuit.version()].Complete)
+	require.False(t, infos["oldversion"].Current)
+	require.False(t, infos["oldversion"].Complete)
+	require.Equal(t, int64(3), infos["oldversion"].Size)
+	usage, err := id.ZkArtifacts.Usage()
+	require.Nil(t, err)
+
+	// The old version is evicted first
+	require.Nil(t, id.ZkArtifacts.Evict(usage-1))
+	_, err = os.Stat(oldVersionDir)
+	require.True(t, os.IsNotExist(err))
+	progress, err = id.ZkArtifacts.Progress(circuit.name)
+	require.Nil(t, err)
+	require.True(t, progress.Complete)
+	require.Nil(t, id.ZkArtifacts.Evict(0))
+	usage, err = id.ZkArtifacts.Usage()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), usage)
+
+	// The fetched artifacts are pinned while a proof is generated with them
+	zkFiles, err = id.ZkArtifacts.fetch(circuit, server.URL+"/"+circuit.urlPath)
+	require.Nil(t, err)
+	require.Equal(t, ErrZkArtifactsInUse, id.ZkArtifacts.Delete(circuit.name, circuit.version()))
+	require.Error(t, id.ZkArtifacts.Evict(0))
+	progress, err = id.ZkArtifacts.Progress(circuit.name)
+	require.Nil(t, err)
+	require.True(t, progress.Complete)
+	unpinZkArtifacts(zkFiles.Path)
+	require.Nil(t, id.ZkArtifacts.Evict(0))
+	_, err = os.Stat(zkFiles.Path)
+	require.True(t, os.IsNotExist(err))
+
+	// Pinned artifacts can't be removed
+	zkFiles, err = NewZkArtifacts(sharedDir).zkFiles(circuit, server.URL+"/"+circuit.urlPath, true)
+	require.Nil(t, err)
+	defer unpinZkArtifacts(zkFiles.Path)
+	ticket, err = id.PrefetchZkArtifacts(circuit.name, server.URL)
+	require.Nil(t, err)
+	require.Nil(t, waitEvent(ticket).Err)
+	require.Equal(t, ErrZkArtifactsInUse, id.ZkArtifacts.Delete(circuit.name, circuit.version()))
+	require.Error(t, id.ZkArtifacts.Evict(0))
+	require.Error(t, id.ZkArtifacts.Delete("..", ".."))
+}
+
+func TestZkArtifactsPinnedByIdentity(t *testing.T) {
+	// Serve fake artifacts of the state transition circuit from the relayer
+	artifactsDir, err := ioutil.TempDir("", "zkArtifactsServer")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, artifactsDir)
+	hash := func(b []byte) string {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+	// The verification key is parsed when the identity is loaded: all the points are the infinity
+	g2 := `[["0","0"],["1","0"],["0","0"]]`
+	vk := []byte(`{"vk_alfa_1":["0","1","0"],"vk_beta_2":` + g2 + `,"vk_gamma_2":` + g2 +
+		`,"vk_delta_2":` + g2 + `,"IC":[["0","1","0"]]}`)
+	pk, wasm := []byte("proving key"), []byte("wasm")
+	idStateCircuit := zkCircuits[ZkCircuitIdState]
+	circuit := &zkCircuit{
+		name:    ZkCircuitIdState,
+		urlPath: idStateCircuit.urlPath,
+		hashes: zkutils.ZkFilesHashes{
+			ProvingKey:      hash(pk),
+			VerificationKey: hash(vk),
+			WitnessCalcWASM: hash(wasm),
+		},
+	}
+	zkCircuits[ZkCircuitIdState] = circuit
+	defer func() { zkCircuits[ZkCircuitIdState] = idStateCircuit }()
+	for i, content := range [][]byte{pk, vk, wasm} {
+		require.Nil(t, ioutil.WriteFile(path.Join(artifactsDir, circuit.files()[i].basename), content, 0600))
+	}
+	mux := http.NewServeMux()
+	artifactsPath := "/relayer/" + relayer.PathIdStateArtifacts + "/"
+	mux.Handle(artifactsPath, http.StripPrefix(artifactsPath, http.FileServer(http.Dir(artifactsDir))))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The identities that can publish their state pin the artifacts of the state transition
+	// circuit until they are stopped
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	ids := make([]*Identity, 2)
+	for i := range ids {
+		dir, err := ioutil.TempDir("", "zkArtifactsPinnedTest")
+		require.Nil(t, err)
+		rmDirs = append(rmDirs, dir)
+		ids[i], err = NewIdentityWithIdenPubOnChain(dir, sharedDir, "pass_TestZkArtifactsPinnedByIdentity",
+			idenPubOnChain, server.URL+"/relayer/", c.HolderTicketPeriod, NewBytesArray(), nil)
+		require.Nil(t, err)
+	}
+	za := NewZkArtifacts(sharedDir)
+	dir := za.dir(circuit.name, circuit.version())
+	require.Equal(t, ErrZkArtifactsInUse, za.Delete(circuit.name, circuit.version()))
+
+	// The artifacts are in use while any identity is loaded
+	ids[0].Stop()
+	require.Equal(t, ErrZkArtifactsInUse, za.Delete(circuit.name, circuit.version()))
+	ids[1].Stop()
+	// Stopping again doesn't release the artifacts twice
+	ids[1].Stop()
+	require.Nil(t, za.Evict(0))
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+}
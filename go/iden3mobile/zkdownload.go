@@ -0,0 +1,394 @@
+package iden3mobile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The ZK artifacts are downloaded in chunks with HTTP Range requests, so that an interrupted
+// download is resumed where it stopped, from the verifier or relayer or any of the mirrors of the
+// circuit. The partial download of each artifact is stored next to it together with a checkpoint
+// of its length and the state of its hash, so that a corrupted partial download is detected and
+// discarded, and the hash of the complete artifact is checked without reading it again.
+
+const (
+	// zkDownloadChunkSize is the size of the chunks requested with HTTP Range
+	zkDownloadChunkSize = 4 << 20
+	// zkDownloadAttempts is the number of failed requests in a row to a url before trying the next mirror
+	zkDownloadAttempts = 3
+	// zkDownloadRetryPeriod is the time to wait before retrying a failed request
+	zkDownloadRetryPeriod = 1 * time.Second
+	// zkCheckpointSuffix is the suffix of the checkpoint of an artifact being downloaded
+	zkCheckpointSuffix = ".checkpoint"
+)
+
+var zkMirrors = struct {
+	sync.RWMutex
+	mirrors map[string][]string
+}{
+	mirrors: make(map[string][]string),
+}
+
+// SetZkArtifactsMirrors sets the mirrors of the ZK artifacts of the circuit: urls that serve the
+// same artifacts as the verifier or relayer (that is, <mirror>/verification_key.json and so on).
+// The artifacts are downloaded from the mirrors, in order, when the verifier or relayer fails.
+func SetZkArtifactsMirrors(circuit string, mirrors *StringArray) error {
+	if _, err := getZkCircuit(circuit); err != nil {
+		return err
+	}
+	urls := make([]string, 0, mirrors.Len())
+	for i := 0; i < mirrors.Len(); i++ {
+		u, err := url.Parse(mirrors.Get(i))
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("Invalid mirror url: %v", mirrors.Get(i))
+		}
+		urls = append(urls, strings.TrimSuffix(u.String(), "/"))
+	}
+	zkMirrors.Lock()
+	defer zkMirrors.Unlock()
+	zkMirrors.mirrors[circuit] = urls
+	return nil
+}
+
+// zkArtifactsUrls returns the urls from where the artifacts of the circuit are downloaded: url
+// followed by the mirrors of the circuit.
+func zkArtifactsUrls(circuit *zkCircuit, url string) []string {
+	zkMirrors.RLock()
+	defer zkMirrors.RUnlock()
+	return append([]string{strings.TrimSuffix(url, "/")}, zkMirrors.mirrors[circuit.name]...)
+}
+
+func newZkDownloadClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, 10*time.Second)
+			},
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		Timeout: 5 * time.Minute,
+	}
+}
+
+func (za *ZkArtifacts) downloadFiles(circuit *zkCircuit, dir, url string, dl *zkDownload) error {
+	if _, err := za.zkFiles(circuit, url, false); err != nil {
+		return err
+	}
+	urls := zkArtifactsUrls(circuit, url)
+	client := newZkDownloadClient()
+	var missing []zkArtifactFile
+	for _, file := range circuit.files() {
+		filename := path.Join(dir, file.basename)
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			missing = append(missing, file)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := checkFileHash(filename, file.hash); err != nil {
+			log.WithError(err).WithField("filename", filename).Warn("Removing corrupted ZK artifact")
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+			missing = append(missing, file)
+			continue
+		}
+		dl.addProgress(info.Size(), info.Size())
+	}
+	// Find out the size of the missing artifacts, to report the progress
+	for _, file := range missing {
+		for _, url := range urls {
+			res, err := client.Head(fmt.Sprintf("%s/%s", url, file.basename))
+			if err != nil {
+				continue
+			}
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK && res.ContentLength > 0 {
+				dl.addProgress(0, res.ContentLength)
+				break
+			}
+		}
+	}
+	for _, file := range missing {
+		if err := downloadFile(client, urls, file, path.Join(dir, file.basename), dl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadFile downloads the artifact file into filename from the first of urls that works,
+// resuming its partial download, and checks its hash.
+func downloadFile(client *http.Client, urls []string, file zkArtifactFile, filename string, dl *zkDownload) error {
+	p, err := openZkPartial(filename+zkArtifactsTmpSuffix, dl)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	dl.addProgress(p.offset, 0)
+	var lastErr error
+	for _, url := range urls {
+		fileUrl := fmt.Sprintf("%s/%s", url, file.basename)
+		log.WithField("url", fileUrl).WithField("offset", p.offset).Info("Downloading ZK artifact")
+		for attempt := 0; attempt < zkDownloadAttempts; {
+			complete, err := p.downloadChunk(client, fileUrl)
+			if err != nil {
+				log.WithError(err).WithField("url", fileUrl).Warn("Error downloading ZK artifact")
+				lastErr = err
+				attempt++
+				time.Sleep(zkDownloadRetryPeriod)
+				continue
+			}
+			if !complete {
+				attempt = 0
+				continue
+			}
+			if h := hex.EncodeToString(p.hasher.Sum(nil)); h != file.hash {
+				// Try again from the next mirror
+				lastErr = fmt.Errorf("Hash mismatch of %v from %v: expected %v but got %v",
+					file.basename, url, file.hash, h)
+				log.Warn(lastErr)
+				if err := p.reset(); err != nil {
+					return err
+				}
+				break
+			}
+			return p.finish(filename)
+		}
+	}
+	return lastErr
+}
+
+// checkFileHash checks that the sha256 hash of the file is hash (in hex).
+func checkFileHash(filename, hash string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	if h := hex.EncodeToString(hasher.Sum(nil)); h != hash {
+		return fmt.Errorf("Hash mismatch of %v: expected %v but got %v", path.Base(filename), hash, h)
+	}
+	return nil
+}
+
+// zkCheckpoint is the checkpoint of a partial download: its length and the state of its hash.
+type zkCheckpoint struct {
+	Offset    int64
+	HashState []byte
+}
+
+// zkPartial is a partial download of an artifact.
+type zkPartial struct {
+	filename string
+	f        *os.File
+	hasher   hash.Hash
+	offset   int64
+	dl       *zkDownload
+}
+
+// openZkPartial opens the partial download stored in filename, which is resumed from its
+// checkpoint, or discarded if it doesn't match the checkpoint.
+func openZkPartial(filename string, dl *zkDownload) (*zkPartial, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	p := &zkPartial{filename: filename, f: f, hasher: sha256.New(), dl: dl}
+	var cp zkCheckpoint
+	if cpJSON, err := ioutil.ReadFile(filename + zkCheckpointSuffix); err == nil {
+		if err := json.Unmarshal(cpJSON, &cp); err != nil {
+			log.WithError(err).WithField("filename", filename).Warn("Discarding invalid ZK artifact checkpoint")
+		} else if err := p.resume(&cp); err != nil {
+			log.WithError(err).WithField("filename", filename).Warn("Discarding partial ZK artifact")
+			p.hasher, p.offset = sha256.New(), 0
+		}
+	}
+	// Remove the data written after the checkpoint
+	if err := f.Truncate(p.offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(p.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// resume checks the partial download against the checkpoint.
+func (p *zkPartial) resume(cp *zkCheckpoint) error {
+	hasher := sha256.New()
+	if _, err := io.CopyN(hasher, p.f, cp.Offset); err != nil {
+		return err
+	}
+	state, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(state, cp.HashState) {
+		return errors.New("The partial download doesn't match its checkpoint")
+	}
+	p.hasher, p.offset = hasher, cp.Offset
+	return nil
+}
+
+// Write appends b to the partial download
+func (p *zkPartial) Write(b []byte) (int, error) {
+	n, err := p.f.Write(b)
+	p.hasher.Write(b[:n])
+	p.offset += int64(n)
+	p.dl.addProgress(int64(n), 0)
+	return n, err
+}
+
+// checkpoint stores the checkpoint of the partial download.
+func (p *zkPartial) checkpoint() error {
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	state, err := p.hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	cpJSON, err := json.Marshal(zkCheckpoint{Offset: p.offset, HashState: state})
+	if err != nil {
+		return err
+	}
+	cpFilename := p.filename + zkCheckpointSuffix
+	if err := ioutil.WriteFile(cpFilename+".tmp", cpJSON, 0600); err != nil {
+		return err
+	}
+	return os.Rename(cpFilename+".tmp", cpFilename)
+}
+
+// copyFrom appends r to the partial download, storing a checkpoint after every chunk and when
+// r fails.
+func (p *zkPartial) copyFrom(r io.Reader) error {
+	for {
+		n, err := io.CopyN(p, r, zkDownloadChunkSize)
+		if n > 0 {
+			if err := p.checkpoint(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// reset discards the partial download.
+func (p *zkPartial) reset() error {
+	p.dl.addProgress(-p.offset, 0)
+	p.hasher, p.offset = sha256.New(), 0
+	if err := p.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := p.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := os.Remove(p.filename + zkCheckpointSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// downloadChunk downloads the next chunk of the artifact at url. It returns true when the
+// download is complete.
+func (p *zkPartial) downloadChunk(client *http.Client, url string) (bool, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", p.offset, p.offset+zkDownloadChunkSize-1))
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		var start, end, total int64
+		if _, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			return false, fmt.Errorf("Invalid Content-Range from %v: %w", url, err)
+		}
+		if start != p.offset {
+			return false, fmt.Errorf("Unexpected range from %v: %v", url, res.Header.Get("Content-Range"))
+		}
+		if err := p.copyFrom(res.Body); err != nil {
+			return false, err
+		}
+		return p.offset >= total, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial download may be the complete artifact
+		var total int64
+		if _, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes */%d", &total); err == nil && total == p.offset {
+			return true, nil
+		}
+		if err := p.reset(); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("The partial download of %v is longer than the artifact", url)
+	case http.StatusOK:
+		// The server doesn't support ranges, so the whole artifact is sent
+		if err := p.reset(); err != nil {
+			return false, err
+		}
+		if err := p.copyFrom(res.Body); err != nil {
+			return false, err
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("HTTP Status: %v for %v", res.Status, url)
+	}
+}
+
+// finish moves the complete download to filename.
+func (p *zkPartial) finish(filename string) error {
+	if err := p.f.Close(); err != nil {
+		return err
+	}
+	p.f = nil
+	if err := os.Rename(p.filename, filename); err != nil {
+		return err
+	}
+	if err := os.Remove(p.filename + zkCheckpointSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (p *zkPartial) close() {
+	if p.f != nil {
+		p.f.Close()
+	}
+}
@@ -0,0 +1,149 @@
+package iden3mobile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testZkArtifactServer serves an artifact, recording the requested ranges
+type testZkArtifactServer struct {
+	content []byte
+	// noRanges makes the server ignore the Range header
+	noRanges bool
+	// fail makes the server fail all the requests
+	fail   bool
+	m      sync.Mutex
+	ranges []string
+}
+
+func (s *testZkArtifactServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	if s.noRanges {
+		r.Header.Del("Range")
+	}
+	http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(s.content))
+}
+
+func TestZkDownload(t *testing.T) {
+	content := make([]byte, zkDownloadChunkSize+zkDownloadChunkSize/2)
+	_, err := rand.Read(content)
+	require.Nil(t, err)
+	h := sha256.Sum256(content)
+	file := zkArtifactFile{basename: "artifact", hash: hex.EncodeToString(h[:])}
+	dir, err := ioutil.TempDir("", "zkDownloadTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	filename := path.Join(dir, file.basename)
+	client := newZkDownloadClient()
+
+	primary := &testZkArtifactServer{content: content}
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+	mirror := &testZkArtifactServer{content: content}
+	mirrorServer := httptest.NewServer(mirror)
+	defer mirrorServer.Close()
+	urls := []string{primaryServer.URL, mirrorServer.URL}
+
+	// writePartial stores a partial download with a checkpoint of the first n bytes
+	writePartial := func(n int) {
+		p, err := openZkPartial(filename+zkArtifactsTmpSuffix, &zkDownload{})
+		require.Nil(t, err)
+		_, err = p.Write(content[:n])
+		require.Nil(t, err)
+		require.Nil(t, p.checkpoint())
+		p.close()
+	}
+
+	// The artifact is downloaded in chunks
+	dl := &zkDownload{}
+	require.Nil(t, downloadFile(client, urls, file, filename, dl))
+	downloaded, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+	require.Equal(t, int64(len(content)), dl.progress.Bytes)
+	require.Equal(t, []string{"bytes=0-4194303", "bytes=4194304-8388607"}, primary.ranges)
+	_, err = os.Stat(filename + zkArtifactsTmpSuffix + zkCheckpointSuffix)
+	require.True(t, os.IsNotExist(err))
+
+	// An interrupted download is resumed from its checkpoint, ignoring the data written after it
+	require.Nil(t, os.Remove(filename))
+	primary.ranges = nil
+	writePartial(1000)
+	f, err := os.OpenFile(filename+zkArtifactsTmpSuffix, os.O_APPEND|os.O_WRONLY, 0600)
+	require.Nil(t, err)
+	_, err = f.Write([]byte("garbage"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	require.Nil(t, downloadFile(client, urls, file, filename, &zkDownload{}))
+	downloaded, err = ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+	require.Equal(t, "bytes=1000-4195303", primary.ranges[0])
+
+	// A partial download that doesn't match its checkpoint is discarded
+	require.Nil(t, os.Remove(filename))
+	primary.ranges = nil
+	writePartial(1000)
+	f, err = os.OpenFile(filename+zkArtifactsTmpSuffix, os.O_WRONLY, 0600)
+	require.Nil(t, err)
+	_, err = f.Write([]byte("corrupted"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	require.Nil(t, downloadFile(client, urls, file, filename, &zkDownload{}))
+	downloaded, err = ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+	require.Equal(t, "bytes=0-4194303", primary.ranges[0])
+
+	// The artifact is downloaded from the mirror when the primary url fails
+	require.Nil(t, os.Remove(filename))
+	primary.fail = true
+	mirror.ranges = nil
+	writePartial(1000)
+	require.Nil(t, downloadFile(client, urls, file, filename, &zkDownload{}))
+	downloaded, err = ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+	require.Equal(t, "bytes=1000-4195303", mirror.ranges[0])
+
+	// Servers that don't support ranges send the whole artifact
+	require.Nil(t, os.Remove(filename))
+	primary.fail = false
+	primary.noRanges = true
+	writePartial(1000)
+	require.Nil(t, downloadFile(client, urls, file, filename, &zkDownload{}))
+	downloaded, err = ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+
+	// An artifact with a wrong hash is not stored
+	require.Nil(t, os.Remove(filename))
+	file.hash = hex.EncodeToString(make([]byte, 32))
+	require.Error(t, downloadFile(client, urls, file, filename, &zkDownload{}))
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+
+	// Invalid mirrors
+	require.Error(t, SetZkArtifactsMirrors("unknown", NewStringArray()))
+	mirrors := NewStringArray()
+	mirrors.Append("ftp://example.com")
+	require.Error(t, SetZkArtifactsMirrors(ZkCircuitClaimDemo, mirrors))
+}
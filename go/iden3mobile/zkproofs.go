@@ -0,0 +1,201 @@
+package iden3mobile
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/iden3/go-iden3-core/db"
+	"github.com/iden3/go-iden3-core/merkletree"
+	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+// Generating a zero knowledge proof of a credential takes a long time on phones, so the proofs are
+// stored and used again while they are fresh: while the issuer state they prove is the last one
+// on chain (otherwise the verifiers could reject them as outdated) and, for precomputed proofs,
+// within their validity window. A proof is only valid for a credential and a version of a circuit
+// (and query).
+
+const zkProofsPrefix = "zkProofs"
+
+// zkProofCached is a stored zero knowledge proof of a credential
+type zkProofCached struct {
+	Proof *verifierMsg.ReqVerifyZkp
+	// CreatedAt is the time (unix seconds) when the proof was generated
+	CreatedAt int64
+	// ValidUntil is the time (unix seconds) after which the proof is not used. If 0, the proof
+	// is used while the issuer state is the last one on chain.
+	ValidUntil int64
+}
+
+func zkProofKey(credID, key string) []byte {
+	return []byte(credID + "/" + key)
+}
+
+func (i *Identity) zkProofsStorage() db.Storage {
+	return i.storage.WithPrefix([]byte(zkProofsPrefix))
+}
+
+// storeZkProof stores the proof of the credential for the circuit or query key (see queryZkProofKey)
+func (i *Identity) storeZkProof(credID, key string, proof *zkProofCached) error {
+	tx, err := i.zkProofsStorage().NewTx()
+	if err != nil {
+		return err
+	}
+	if err := db.StoreJSON(tx, zkProofKey(credID, key), proof); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteZkProof discards the proof of the credential for the circuit or query key. As the storage
+// doesn't support deletions, an empty proof is stored instead.
+func (i *Identity) deleteZkProof(credID, key string) error {
+	return i.storeZkProof(credID, key, &zkProofCached{})
+}
+
+// loadFreshZkProof returns the stored proof of the credential for the circuit or query key, or nil if
+// there isn't any or it's not fresh.
+func (i *Identity) loadFreshZkProof(credID, key string) *zkProofCached {
+	var proof zkProofCached
+	if err := db.LoadJSON(i.zkProofsStorage(), zkProofKey(credID, key), &proof); err != nil {
+		return nil
+	}
+	if proof.ValidUntil != 0 && time.Now().Unix() > proof.ValidUntil {
+		return nil
+	}
+	if proof.Proof == nil || proof.Proof.IssuerID == nil || len(proof.Proof.PubSignals) == 0 {
+		return nil
+	}
+	idenStateData, err := i.idenPubOnChain.GetState(proof.Proof.IssuerID)
+	if err != nil {
+		log.WithError(err).Warn("Error checking the freshness of a cached ZK proof")
+		return nil
+	}
+	idenState := merkletree.NewHashFromBigInt(proof.Proof.PubSignals[0])
+	if idenStateData.BlockN != proof.Proof.IdenStateBlockN || !idenStateData.IdenState.Equals(idenState) {
+		return nil
+	}
+	return &proof
+}
+
+// zkProofCredential returns a fresh stored zero knowledge proof of the credential (or of the query,
+// if it's not nil), or generates and stores a new one. The result is true if the proof was stored.
+func (i *Identity) zkProofCredential(baseUrl, credID string, query *ZkQuery) (*verifierMsg.ReqVerifyZkp, bool, error) {
+	key, err := queryZkProofKey(query)
+	if err != nil {
+		return nil, false, err
+	}
+	if proof := i.loadFreshZkProof(credID, key); proof != nil {
+		log.WithField("credID", credID).Debug("Using cached ZK proof")
+		return proof.Proof, true, nil
+	}
+	zkp, err := i.genZkProofCredential(baseUrl, credID, query)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := i.storeZkProof(credID, key, &zkProofCached{
+		Proof:     zkp,
+		CreatedAt: time.Now().Unix(),
+	}); err != nil {
+		return nil, false, err
+	}
+	return zkp, false, nil
+}
+
+// zkProofRejected returns true if err is the answer of a verifier that rejected the proof, as opposed to
+// a transport or server error.
+func zkProofRejected(err error) bool {
+	var statusErr StatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnprocessableEntity)
+}
+
+// proveClaimZK sends a zero knowledge proof of the credential (or of the query, if it's not nil) to the
+// verifier endpoint at baseUrl+path. If the verifier rejects a cached proof, it may require a fresher one,
+// so the proof is discarded and a new one is generated and sent.
+func (i *Identity) proveClaimZK(baseUrl, credID string, query *ZkQuery, path string) (bool, error) {
+	key, err := queryZkProofKey(query)
+	if err != nil {
+		return false, err
+	}
+	reqVerifyZkp, cached, err := i.zkProofCredential(baseUrl, credID, query)
+	if err != nil {
+		return false, err
+	}
+	httpClient := NewHttpClient(baseUrl)
+	err = httpClient.DoRequest(httpClient.NewRequest().Path(path).Post("").BodyJSON(reqVerifyZkp), nil)
+	if err != nil && cached && zkProofRejected(err) {
+		log.WithError(err).Warn("Cached ZK proof rejected, generating a new one")
+		if err := i.deleteZkProof(credID, key); err != nil {
+			return false, err
+		}
+		if reqVerifyZkp, _, err = i.zkProofCredential(baseUrl, credID, query); err != nil {
+			return false, err
+		}
+		err = httpClient.DoRequest(httpClient.NewRequest().Path(path).Post("").BodyJSON(reqVerifyZkp), nil)
+	}
+	if err != nil {
+		return false, err
+	}
+	i.recordContact(contactVerifier)
+	return true, nil
+}
+
+// PrecomputeProofZK generates the zero knowledge proof of the credential ahead of time (for
+// example while the phone is charging), so that ProveClaimZK and PresentClaimZK don't need to
+// generate it. The proof is used during validitySeconds, if the issuer doesn't publish a new
+// state before. If validitySeconds is 0, it's used until the issuer publishes a new state.
+// The ZK artifacts are downloaded from baseUrl if needed.
+func (i *Identity) PrecomputeProofZK(baseUrl, credID string, validitySeconds int) error {
+	if err := i.begin(); err != nil {
+		return err
+	}
+	defer i.end()
+	return i.precomputeProofZK(baseUrl, credID, nil, validitySeconds)
+}
+
+func (i *Identity) precomputeProofZK(baseUrl, credID string, query *ZkQuery, validitySeconds int) error {
+	key, err := queryZkProofKey(query)
+	if err != nil {
+		return err
+	}
+	zkp, err := i.genZkProofCredential(baseUrl, credID, query)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	proof := &zkProofCached{
+		Proof:     zkp,
+		CreatedAt: now,
+	}
+	if validitySeconds > 0 {
+		proof.ValidUntil = now + int64(validitySeconds)
+	}
+	return i.storeZkProof(credID, key, proof)
+}
+
+// CallbackPrecomputeProofZK is a interface used to get an asynchronous response from PrecomputeProofZKWithCb
+type CallbackPrecomputeProofZK interface {
+	Fn(error)
+}
+
+// PrecomputeProofZKWithCb is the asynchronous version of PrecomputeProofZK.
+func (i *Identity) PrecomputeProofZKWithCb(baseUrl, credID string, validitySeconds int, c CallbackPrecomputeProofZK) {
+	i.async(func() { c.Fn(i.PrecomputeProofZK(baseUrl, credID, validitySeconds)) })
+}
+
+// HasFreshProofZK returns true if there is a fresh precomputed or cached zero knowledge proof of
+// the credential.
+func (i *Identity) HasFreshProofZK(credID string) bool {
+	if err := i.begin(); err != nil {
+		return false
+	}
+	defer i.end()
+	key, err := queryZkProofKey(nil)
+	if err != nil {
+		return false
+	}
+	return i.loadFreshZkProof(credID, key) != nil
+}
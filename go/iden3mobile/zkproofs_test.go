@@ -0,0 +1,134 @@
+package iden3mobile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iden3/go-iden3-core/components/idenpubonchain"
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/core/proof"
+	"github.com/iden3/go-iden3-core/merkletree"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// testStateOnChain returns state as the last state of any identity
+type testStateOnChain struct {
+	idenpubonchain.IdenPubOnChainer
+	state *proof.IdenStateData
+}
+
+func (s *testStateOnChain) GetState(id *core.ID) (*proof.IdenStateData, error) {
+	return s.state, nil
+}
+
+func TestZkProofsCache(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "zkProofsTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestZkProofsCache", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	idenState := merkletree.NewHashFromBigInt(big.NewInt(42))
+	onChain := &testStateOnChain{state: &proof.IdenStateData{BlockN: 10, IdenState: idenState}}
+	id.idenPubOnChain = onChain
+	zkp := &verifierMsg.ReqVerifyZkp{
+		PubSignals:      zkutils.PubSignals{idenState.BigInt()},
+		IssuerID:        id.id.ID(),
+		IdenStateBlockN: 10,
+	}
+	credID := "credID"
+	key, err := queryZkProofKey(nil)
+	require.Nil(t, err)
+
+	// No proof stored
+	require.False(t, id.HasFreshProofZK(credID))
+
+	// The proof is fresh while the issuer state is the last one on chain
+	require.Nil(t, id.storeZkProof(credID, key, &zkProofCached{Proof: zkp}))
+	require.True(t, id.HasFreshProofZK(credID))
+	zkp2, cached, err := id.zkProofCredential("", credID, nil)
+	require.Nil(t, err)
+	require.True(t, cached)
+	require.Equal(t, zkp, zkp2)
+	onChain.state = &proof.IdenStateData{BlockN: 11, IdenState: merkletree.NewHashFromBigInt(big.NewInt(43))}
+	require.False(t, id.HasFreshProofZK(credID))
+	onChain.state = &proof.IdenStateData{BlockN: 10, IdenState: idenState}
+
+	// Precomputed proofs expire
+	require.Nil(t, id.storeZkProof(credID, key, &zkProofCached{
+		Proof:      zkp,
+		ValidUntil: time.Now().Unix() - 1,
+	}))
+	require.False(t, id.HasFreshProofZK(credID))
+	require.Nil(t, id.storeZkProof(credID, key, &zkProofCached{
+		Proof:      zkp,
+		ValidUntil: time.Now().Unix() + 60,
+	}))
+	require.True(t, id.HasFreshProofZK(credID))
+
+	// Discarded proofs are not used
+	require.Nil(t, id.deleteZkProof(credID, key))
+	require.False(t, id.HasFreshProofZK(credID))
+}
+
+func TestZkProofRejected(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "zkProofRejectedTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestZkProofRejected", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id.Stop()
+
+	idenState := merkletree.NewHashFromBigInt(big.NewInt(42))
+	id.idenPubOnChain = &testStateOnChain{state: &proof.IdenStateData{BlockN: 10, IdenState: idenState}}
+	credID := "credID"
+	key, err := queryZkProofKey(nil)
+	require.Nil(t, err)
+	require.Nil(t, id.storeZkProof(credID, key, &zkProofCached{Proof: &verifierMsg.ReqVerifyZkp{
+		PubSignals:      zkutils.PubSignals{idenState.BigInt()},
+		IssuerID:        id.id.ID(),
+		IdenStateBlockN: 10,
+	}}))
+
+	status := http.StatusInternalServerError
+	verifications := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/credentialDemo/verifyzkp", func(w http.ResponseWriter, r *http.Request) {
+		verifications++
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"error": "verification failed"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// A server error doesn't discard the cached proof
+	_, err = id.ProveClaimZK(server.URL+"/", credID)
+	require.Error(t, err)
+	require.Equal(t, http.StatusInternalServerError, err.(StatusError).StatusCode)
+	require.Equal(t, 1, verifications)
+	require.True(t, id.HasFreshProofZK(credID))
+
+	// A rejected proof is discarded and a new one is generated, which fails as the credential
+	// doesn't exist
+	status = http.StatusBadRequest
+	_, err = id.ProveClaimZK(server.URL+"/", credID)
+	require.Error(t, err)
+	require.Equal(t, 2, verifications)
+	require.False(t, id.HasFreshProofZK(credID))
+}
@@ -0,0 +1,250 @@
+package iden3mobile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/iden3/go-iden3-core/core/claims"
+	"github.com/iden3/go-iden3-core/merkletree"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	cryptoUtils "github.com/iden3/go-iden3-crypto/utils"
+)
+
+// A ZK query proves a predicate over a slot of a credential claim (for example, that the birth date is
+// before a given date, so the holder is over 18) without revealing the slot. The predicate is checked by
+// a query circuit, that must be registered with RegisterZkQueryCircuit. Besides the inputs of the
+// credential circuit, a query circuit takes these inputs:
+// - claim: the 8 elements of the claim (private)
+// - querySlot: the index of the claim element checked by the predicate
+// - operator: the predicate, one of the ZkQueryOperator constants
+// - values: the values of the predicate, padded with zeros to zkQueryMaxValues elements
+// The query (querySlot, operator and values) must be public signals, so the verifier can check that the
+// proof answers its query.
+
+const (
+	// ZkQueryOperatorEq proves that the slot is equal to the value
+	ZkQueryOperatorEq = 1
+	// ZkQueryOperatorLt proves that the slot is less than the value
+	ZkQueryOperatorLt = 2
+	// ZkQueryOperatorGt proves that the slot is greater than the value
+	ZkQueryOperatorGt = 3
+	// ZkQueryOperatorIn proves that the slot is one of the values
+	ZkQueryOperatorIn = 4
+)
+
+// zkQueryMaxValues is the number of values supported by the query circuits
+const zkQueryMaxValues = 16
+
+var ErrZkQueryNotSatisfied = errors.New("The credential doesn't satisfy the ZK query")
+
+// zkQueryCircuits are the query circuits registered with RegisterZkQueryCircuit
+var zkQueryCircuits = struct {
+	sync.RWMutex
+	circuits map[string]*zkCircuit
+}{
+	circuits: make(map[string]*zkCircuit),
+}
+
+// RegisterZkQueryCircuit registers a circuit that proves ZK queries. The verifiers that support the
+// circuit serve its ZK artifacts at baseUrl+basePath+"/artifacts" and verify its proofs at
+// baseUrl+basePath+"/verifyzkp". The hashes are the hex encoded sha256 of the proving key, the
+// verification key and the witness calculator WASM.
+func RegisterZkQueryCircuit(name, basePath, provingKeyHash, verificationKeyHash, witnessCalcWASMHash string) error {
+	if _, ok := zkCircuits[name]; ok {
+		return fmt.Errorf("The ZK circuit %v is not a query circuit", name)
+	}
+	if name == "" || strings.ContainsAny(name, "/\\.") {
+		return fmt.Errorf("Invalid ZK circuit name: %v", name)
+	}
+	for _, h := range []string{provingKeyHash, verificationKeyHash, witnessCalcWASMHash} {
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("Invalid ZK artifact hash: %v", h)
+		}
+	}
+	zkQueryCircuits.Lock()
+	defer zkQueryCircuits.Unlock()
+	zkQueryCircuits.circuits[name] = &zkCircuit{
+		name:    name,
+		urlPath: strings.Trim(basePath, "/") + "/artifacts",
+		hashes: zkutils.ZkFilesHashes{
+			ProvingKey:      provingKeyHash,
+			VerificationKey: verificationKeyHash,
+			WitnessCalcWASM: witnessCalcWASMHash,
+		},
+	}
+	return nil
+}
+
+func getZkQueryCircuit(name string) (*zkCircuit, error) {
+	zkQueryCircuits.RLock()
+	defer zkQueryCircuits.RUnlock()
+	circuit, ok := zkQueryCircuits.circuits[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown ZK query circuit: %v", name)
+	}
+	return circuit, nil
+}
+
+// ZkQuery is a predicate over a slot of a claim, proved with a query circuit.
+type ZkQuery struct {
+	Circuit  string
+	Slot     int
+	Operator int
+	values   []*big.Int
+}
+
+// NewZkQuery creates a query of the predicate operator over the claim element at slot (0 to 7), that
+// will be proved with the query circuit. The values of the predicate are added with AddValue.
+// this funciton is mapped as a constructor in Java.
+func NewZkQuery(circuit string, slot, operator int) (*ZkQuery, error) {
+	if _, err := getZkQueryCircuit(circuit); err != nil {
+		return nil, err
+	}
+	if slot < 0 || slot >= len(merkletree.Data{}) {
+		return nil, fmt.Errorf("Invalid ZK query slot: %v", slot)
+	}
+	if operator < ZkQueryOperatorEq || operator > ZkQueryOperatorIn {
+		return nil, fmt.Errorf("Invalid ZK query operator: %v", operator)
+	}
+	return &ZkQuery{Circuit: circuit, Slot: slot, Operator: operator}, nil
+}
+
+// AddValue adds a value, as a decimal number, to the predicate. The operators Eq, Lt and Gt take
+// one value, and In takes up to 16 values.
+func (q *ZkQuery) AddValue(value string) error {
+	v, ok := new(big.Int).SetString(value, 10)
+	if !ok || v.Sign() < 0 || !cryptoUtils.CheckBigIntInField(v) {
+		return fmt.Errorf("Invalid ZK query value: %v", value)
+	}
+	if (q.Operator != ZkQueryOperatorIn && len(q.values) == 1) || len(q.values) == zkQueryMaxValues {
+		return errors.New("Too many ZK query values")
+	}
+	q.values = append(q.values, v)
+	return nil
+}
+
+func (q *ZkQuery) validate() error {
+	if len(q.values) == 0 {
+		return errors.New("The ZK query doesn't have values")
+	}
+	return nil
+}
+
+// key identifies the query in the proofs cache
+func (q *ZkQuery) key() string {
+	j, _ := json.Marshal(struct {
+		Slot     int
+		Operator int
+		Values   []*big.Int
+	}{q.Slot, q.Operator, q.values})
+	h := sha256.Sum256(j)
+	return q.Circuit + "/" + hex.EncodeToString(h[:8])
+}
+
+// satisfied returns true if the claim satisfies the predicate
+func (q *ZkQuery) satisfied(claim *merkletree.Entry) bool {
+	v := claim.Data[q.Slot].BigInt()
+	switch q.Operator {
+	case ZkQueryOperatorEq:
+		return v.Cmp(q.values[0]) == 0
+	case ZkQueryOperatorLt:
+		return v.Cmp(q.values[0]) < 0
+	case ZkQueryOperatorGt:
+		return v.Cmp(q.values[0]) > 0
+	case ZkQueryOperatorIn:
+		for _, value := range q.values {
+			if v.Cmp(value) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addInputs returns the function that adds the inputs of the query circuit
+func (q *ZkQuery) addInputs(id *Identity, claim *merkletree.Entry) func(inputs map[string]interface{}) error {
+	return func(inputs map[string]interface{}) error {
+		var metadata claims.Metadata
+		metadata.Unmarshal(claim)
+		claimInputs := make([]*big.Int, len(claim.Data))
+		for i := range claim.Data {
+			claimInputs[i] = claim.Data[i].BigInt()
+		}
+		values := make([]*big.Int, zkQueryMaxValues)
+		for i := range values {
+			values[i] = new(big.Int)
+			if i < len(q.values) {
+				values[i].Set(q.values[i])
+			}
+		}
+		inputs["claim"] = claimInputs
+		inputs["id"] = id.id.ID().BigInt()
+		inputs["revNonce"] = new(big.Int).SetUint64(uint64(metadata.RevNonce))
+		inputs["querySlot"] = big.NewInt(int64(q.Slot))
+		inputs["operator"] = big.NewInt(int64(q.Operator))
+		inputs["values"] = values
+		return nil
+	}
+}
+
+// ProveClaimZKQuery sends to a verifier a zero knowledge proof that the claim of the given
+// credentialExistance satisfies the query, without revealing the claim. The response should be true
+// if the verifier accepted the proof as valid. If the claim doesn't satisfy the query,
+// ErrZkQueryNotSatisfied is returned without generating the proof.
+// A fresh precomputed or cached proof is used instead of generating a new one.
+func (i *Identity) ProveClaimZKQuery(baseUrl, credID string, query *ZkQuery) (bool, error) {
+	if err := i.begin(); err != nil {
+		return false, err
+	}
+	defer i.end()
+	if err := query.validate(); err != nil {
+		return false, err
+	}
+	circuit, err := getZkQueryCircuit(query.Circuit)
+	if err != nil {
+		return false, err
+	}
+	return i.proveClaimZK(baseUrl, credID, query, strings.TrimSuffix(circuit.urlPath, "artifacts")+"verifyzkp")
+}
+
+// ProveClaimZKQueryWithCb is the asynchronous version of ProveClaimZKQuery.
+func (i *Identity) ProveClaimZKQueryWithCb(baseUrl, credID string, query *ZkQuery, c CallbackProveClaim) {
+	i.async(func() { c.Fn(i.ProveClaimZKQuery(baseUrl, credID, query)) })
+}
+
+// PrecomputeProofZKQuery is like PrecomputeProofZK, for the proof of a query.
+func (i *Identity) PrecomputeProofZKQuery(baseUrl, credID string, query *ZkQuery, validitySeconds int) error {
+	if err := i.begin(); err != nil {
+		return err
+	}
+	defer i.end()
+	if err := query.validate(); err != nil {
+		return err
+	}
+	return i.precomputeProofZK(baseUrl, credID, query, validitySeconds)
+}
+
+// PrecomputeProofZKQueryWithCb is the asynchronous version of PrecomputeProofZKQuery.
+func (i *Identity) PrecomputeProofZKQueryWithCb(baseUrl, credID string, query *ZkQuery, validitySeconds int, c CallbackPrecomputeProofZK) {
+	i.async(func() { c.Fn(i.PrecomputeProofZKQuery(baseUrl, credID, query, validitySeconds)) })
+}
+
+// queryZkProofKey returns the key of the proofs of query in the proofs cache. A nil query is the
+// proof of the claimDemo circuit. The key contains the version of the circuit, so that the proofs
+// generated with other artifacts are not used.
+func queryZkProofKey(query *ZkQuery) (string, error) {
+	if query == nil {
+		return ZkCircuitClaimDemo + "/" + zkCircuits[ZkCircuitClaimDemo].version(), nil
+	}
+	circuit, err := getZkQueryCircuit(query.Circuit)
+	if err != nil {
+		return "", err
+	}
+	return query.key() + "/" + circuit.version(), nil
+}
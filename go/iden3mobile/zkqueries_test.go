@@ -0,0 +1,98 @@
+package iden3mobile
+
+import (
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/iden3/go-iden3-core/merkletree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZkQuery(t *testing.T) {
+	hash := strings.Repeat("00", 32)
+	require.Error(t, RegisterZkQueryCircuit(ZkCircuitClaimDemo, "query", hash, hash, hash))
+	require.Error(t, RegisterZkQueryCircuit("../query", "query", hash, hash, hash))
+	require.Error(t, RegisterZkQueryCircuit("query", "query", "00", hash, hash))
+	require.Nil(t, RegisterZkQueryCircuit("query", "/credentialQuery/", hash, hash, hash))
+	defer func() {
+		zkQueryCircuits.Lock()
+		delete(zkQueryCircuits.circuits, "query")
+		zkQueryCircuits.Unlock()
+	}()
+	circuit, err := getZkCircuit("query")
+	require.Nil(t, err)
+	require.Equal(t, "credentialQuery/artifacts", circuit.urlPath)
+
+	// Invalid queries
+	_, err = NewZkQuery("unknown", 2, ZkQueryOperatorEq)
+	require.Error(t, err)
+	_, err = NewZkQuery("query", 8, ZkQueryOperatorEq)
+	require.Error(t, err)
+	_, err = NewZkQuery("query", 2, 0)
+	require.Error(t, err)
+	q, err := NewZkQuery("query", 2, ZkQueryOperatorLt)
+	require.Nil(t, err)
+	require.Error(t, q.validate())
+	require.Error(t, q.AddValue("-1"))
+	require.Error(t, q.AddValue("abc"))
+	require.Nil(t, q.AddValue("20020101"))
+	require.Error(t, q.AddValue("20020102"))
+	require.Nil(t, q.validate())
+
+	// Predicates over the claim slot
+	claim := &merkletree.Entry{}
+	birthDate := merkletree.NewElemBytesFromBigInt(big.NewInt(19900315))
+	claim.Data[2] = birthDate
+	require.True(t, q.satisfied(claim))
+	gt, err := NewZkQuery("query", 2, ZkQueryOperatorGt)
+	require.Nil(t, err)
+	require.Nil(t, gt.AddValue("20020101"))
+	require.False(t, gt.satisfied(claim))
+	in, err := NewZkQuery("query", 2, ZkQueryOperatorIn)
+	require.Nil(t, err)
+	for _, v := range []string{"1", "19900315", "3"} {
+		require.Nil(t, in.AddValue(v))
+	}
+	require.True(t, in.satisfied(claim))
+	eq, err := NewZkQuery("query", 2, ZkQueryOperatorEq)
+	require.Nil(t, err)
+	require.Nil(t, eq.AddValue("19900316"))
+	require.False(t, eq.satisfied(claim))
+
+	// The values are padded in the circuit inputs
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, sharedDir)
+	dir, err := ioutil.TempDir("", "zkQueryTest")
+	require.Nil(t, err)
+	rmDirs = append(rmDirs, dir)
+	id, err := NewIdentityTest(dir, sharedDir, "pass_TestZkQuery", idenPubOnChain,
+		c.HolderTicketPeriod, NewBytesArray(), nil)
+	require.Nil(t, err)
+	defer id.Stop()
+	inputs := make(map[string]interface{})
+	require.Nil(t, in.addInputs(id, claim)(inputs))
+	values := inputs["values"].([]*big.Int)
+	require.Len(t, values, zkQueryMaxValues)
+	require.Equal(t, big.NewInt(19900315), values[1])
+	require.Equal(t, big.NewInt(0), values[zkQueryMaxValues-1])
+	require.Equal(t, big.NewInt(2), inputs["querySlot"])
+	require.Len(t, inputs["claim"], 8)
+
+	// Each query has its own proof in the cache
+	require.NotEqual(t, in.key(), eq.key())
+	eqKey, err := queryZkProofKey(eq)
+	require.Nil(t, err)
+	claimDemoKey, err := queryZkProofKey(nil)
+	require.Nil(t, err)
+	require.NotEqual(t, claimDemoKey, eqKey)
+	require.True(t, strings.HasPrefix(claimDemoKey, ZkCircuitClaimDemo+"/"))
+
+	// The proofs of a previous version of the circuit are not used
+	require.Nil(t, RegisterZkQueryCircuit("query", "/credentialQuery/", hash, hash, strings.Repeat("01", 32)))
+	eqKey2, err := queryZkProofKey(eq)
+	require.Nil(t, err)
+	require.NotEqual(t, eqKey, eqKey2)
+}
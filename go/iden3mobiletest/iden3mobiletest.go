@@ -0,0 +1,160 @@
+// Package iden3mobiletest provides helpers to write tests of apps that use
+// iden3mobile: identities stored in temporary directories that read the
+// state of the identities from the local smart contract of a testenv.Env, a
+// Sender that records the events, and helpers that drive a claim request
+// until the credential is stored.
+package iden3mobiletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/iden3/iden3-mobile/go/iden3mobile"
+	"github.com/iden3/iden3-mobile/go/testenv"
+)
+
+// DefaultCheckTicketsPeriodMilis is the period to check the pending tickets
+// of the identities created by NewIdentity
+const DefaultCheckTicketsPeriodMilis = 1000
+
+// RecordingSender is an iden3mobile.Sender that records the received events.
+type RecordingSender struct {
+	events []*iden3mobile.Event
+	cond   *sync.Cond
+}
+
+// NewRecordingSender creates a new RecordingSender
+func NewRecordingSender() *RecordingSender {
+	return &RecordingSender{cond: sync.NewCond(&sync.Mutex{})}
+}
+
+// Send records the event
+func (rs *RecordingSender) Send(ev *iden3mobile.Event) {
+	rs.cond.L.Lock()
+	defer rs.cond.L.Unlock()
+	rs.events = append(rs.events, ev)
+	rs.cond.Broadcast()
+}
+
+// Events returns the received events in order
+func (rs *RecordingSender) Events() []*iden3mobile.Event {
+	rs.cond.L.Lock()
+	defer rs.cond.L.Unlock()
+	return append([]*iden3mobile.Event{}, rs.events...)
+}
+
+// WaitTicket waits until the event of the ticket ticketId is received, or
+// returns an error after timeout.
+func (rs *RecordingSender) WaitTicket(ticketId string, timeout time.Duration) (*iden3mobile.Event, error) {
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		rs.cond.L.Lock()
+		defer rs.cond.L.Unlock()
+		timedOut = true
+		rs.cond.Broadcast()
+	})
+	defer timer.Stop()
+	rs.cond.L.Lock()
+	defer rs.cond.L.Unlock()
+	for {
+		for _, ev := range rs.events {
+			if ev.TicketId == ticketId {
+				return ev, nil
+			}
+		}
+		if timedOut {
+			return nil, fmt.Errorf("Timeout waiting for the event of ticket %v", ticketId)
+		}
+		rs.cond.Wait()
+	}
+}
+
+// Identity is an iden3mobile.Identity stored in a temporary directory, whose
+// events are recorded in Sender.
+type Identity struct {
+	*iden3mobile.Identity
+	Env             *testenv.Env
+	Sender          *RecordingSender
+	StorePath       string
+	SharedStorePath string
+	Pass            string
+}
+
+// NewIdentity creates a new identity stored in a new temporary directory,
+// that reads the state of the identities from the local smart contract of env.
+// sharedStorePath is shared between identities to keep a single copy of the
+// ZK artifacts. If relayerUrl is empty, the identity is genesis only.
+func NewIdentity(env *testenv.Env, sharedStorePath, pass, relayerUrl string,
+	extraGenesisClaims *iden3mobile.BytesArray) (*Identity, error) {
+	storePath, err := ioutil.TempDir("", "iden3mobiletest")
+	if err != nil {
+		return nil, err
+	}
+	if extraGenesisClaims == nil {
+		extraGenesisClaims = iden3mobile.NewBytesArray()
+	}
+	sender := NewRecordingSender()
+	iden, err := iden3mobile.NewIdentityWithIdenPubOnChain(storePath, sharedStorePath, pass,
+		env.IdenPubOnChain, relayerUrl, DefaultCheckTicketsPeriodMilis, extraGenesisClaims, sender)
+	if err != nil {
+		os.RemoveAll(storePath)
+		return nil, err
+	}
+	return &Identity{
+		Identity:        iden,
+		Env:             env,
+		Sender:          sender,
+		StorePath:       storePath,
+		SharedStorePath: sharedStorePath,
+		Pass:            pass,
+	}, nil
+}
+
+// Reload stops the identity and loads it again from its directory.
+func (i *Identity) Reload() error {
+	i.Identity.Stop()
+	iden, err := iden3mobile.NewIdentityLoadWithIdenPubOnChain(i.StorePath, i.SharedStorePath, i.Pass,
+		i.Env.IdenPubOnChain, DefaultCheckTicketsPeriodMilis, i.Sender)
+	if err != nil {
+		return err
+	}
+	i.Identity = iden
+	return nil
+}
+
+// Remove stops the identity and removes its directory.
+func (i *Identity) Remove() error {
+	i.Identity.Stop()
+	return os.RemoveAll(i.StorePath)
+}
+
+// RequestCredential requests a claim with data to the issuer at issuerUrl and
+// waits until the claim is issued and its credential stored, returning the
+// id of the credential.
+func (i *Identity) RequestCredential(issuerUrl, data string, timeout time.Duration) (string, error) {
+	ticket, err := i.RequestClaim(issuerUrl, data)
+	if err != nil {
+		return "", err
+	}
+	ev, err := i.Sender.WaitTicket(ticket.Id, timeout)
+	if err != nil {
+		return "", err
+	}
+	if ev.Err != nil {
+		return "", ev.Err
+	}
+	var res struct {
+		CredID string
+	}
+	if err := json.Unmarshal([]byte(ev.Data), &res); err != nil {
+		return "", err
+	}
+	if res.CredID == "" {
+		return "", fmt.Errorf("The claim request %v has been rejected", ticket.Id)
+	}
+	return res.CredID, nil
+}
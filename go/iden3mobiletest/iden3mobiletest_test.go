@@ -0,0 +1,154 @@
+package iden3mobiletest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iden3/iden3-mobile/go/iden3mobile"
+	"github.com/iden3/iden3-mobile/go/mockupserver"
+	"github.com/iden3/iden3-mobile/go/testenv"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) {
+	env = testenv.New()
+	os.Exit(m.Run())
+}
+
+func TestRecordingSender(t *testing.T) {
+	rs := NewRecordingSender()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		rs.Send(&iden3mobile.Event{TicketId: "a"})
+		rs.Send(&iden3mobile.Event{TicketId: "b"})
+	}()
+	ev, err := rs.WaitTicket("b", 5*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, "b", ev.TicketId)
+	require.Equal(t, 2, len(rs.Events()))
+	// Already received events are returned without waiting
+	ev, err = rs.WaitTicket("a", time.Millisecond)
+	require.Nil(t, err)
+	require.Equal(t, "a", ev.TicketId)
+	_, err = rs.WaitTicket("c", 100*time.Millisecond)
+	require.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	defer os.RemoveAll(sharedDir)
+	id, err := NewIdentity(env, sharedDir, "pass_TestIdentity", "", nil)
+	require.Nil(t, err)
+	signed, err := id.SignMessage("test", "hello")
+	require.Nil(t, err)
+	require.Nil(t, id.Reload())
+	// The reloaded identity keeps its keys
+	_, err = id.VerifySignature(signed, "test", 60)
+	require.Nil(t, err)
+	require.Nil(t, id.Remove())
+	_, err = os.Stat(id.StorePath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRequestCredential(t *testing.T) {
+	defer env.StartSync(2*time.Second, 10, 100)()
+	server := env.Serve(t, &mockupserver.Conf{
+		IP:                "127.0.0.1",
+		Port:              "1240",
+		TimeToAproveClaim: 1 * time.Second,
+		TimeToPublish:     2 * time.Second,
+		Behaviours: map[string]mockupserver.Behaviour{
+			"rejectme": mockupserver.BehaviourReject,
+		},
+	})
+	defer server.Close()
+	time.Sleep(1 * time.Second)
+	issuerUrl := "http://127.0.0.1:1240/"
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	defer os.RemoveAll(sharedDir)
+	id, err := NewIdentity(env, sharedDir, "pass_TestRequestCredential", "", nil)
+	require.Nil(t, err)
+	defer id.Remove()
+
+	credID, err := id.RequestCredential(issuerUrl, "approveme", 2*time.Minute)
+	require.Nil(t, err)
+	ok, err := id.ProveClaim(issuerUrl, credID)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	_, err = id.RequestCredential(issuerUrl, "rejectme", 2*time.Minute)
+	require.Error(t, err)
+}
+
+func TestMockupServerRestart(t *testing.T) {
+	defer env.StartSync(2*time.Second, 10, 100)()
+	storePath, err := ioutil.TempDir("", "mockupserver")
+	require.Nil(t, err)
+	defer os.RemoveAll(storePath)
+	cfg := &mockupserver.Conf{
+		IP:                "127.0.0.1",
+		Port:              "1241",
+		TimeToAproveClaim: 1 * time.Second,
+		TimeToPublish:     2 * time.Second,
+		Behaviours: map[string]mockupserver.Behaviour{
+			"manualme": mockupserver.BehaviourManual,
+		},
+		StorePath: storePath,
+	}
+	server := env.Serve(t, cfg)
+	time.Sleep(1 * time.Second)
+	issuerUrl := "http://127.0.0.1:1241/"
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	require.Nil(t, err)
+	defer os.RemoveAll(sharedDir)
+	id, err := NewIdentity(env, sharedDir, "pass_TestMockupServerRestart", "", nil)
+	require.Nil(t, err)
+	defer id.Remove()
+	credID, err := id.RequestCredential(issuerUrl, "approveme", 2*time.Minute)
+	require.Nil(t, err)
+	ticket, err := id.RequestClaim(issuerUrl, "manualme")
+	require.Nil(t, err)
+
+	// Restart the server on the same storePath
+	require.Nil(t, server.Shutdown(context.Background()))
+	server = env.Serve(t, cfg)
+	defer server.Close()
+	time.Sleep(1 * time.Second)
+
+	// The issuer keeps its identity and its public data, so the credential is still valid
+	credExist, err := id.ClaimDB.GetCredExist(credID)
+	require.Nil(t, err)
+	res, err := http.Get(credExist.IdenPubUrl + credExist.Id.String())
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	ok, err := id.ProveClaim(issuerUrl, credID)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// The pending request survives, and is issued when approved after the restart
+	httpClient := iden3mobile.NewHttpClient(issuerUrl)
+	var pending []mockupserver.AdminRequest
+	require.Nil(t, httpClient.DoRequest(httpClient.NewRequest().Path("admin/claim/requests").
+		QueryStruct(&struct {
+			Status string `url:"status"`
+		}{Status: "pending"}).Get(""), &pending))
+	require.Len(t, pending, 1)
+	require.Equal(t, "manualme", pending[0].Value)
+	require.Nil(t, httpClient.DoRequest(httpClient.NewRequest().Path(
+		fmt.Sprintf("admin/claim/approve/%v", pending[0].Id)).Post(""), nil))
+	ev, err := id.Sender.WaitTicket(ticket.Id, 2*time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, ev.Err)
+}
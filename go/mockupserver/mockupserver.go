@@ -1,14 +1,26 @@
 package mockupserver
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/gin-gonic/gin"
 	"github.com/iden3/go-iden3-core/components/idenpuboffchain"
 	idenpuboffchainwriterhttp "github.com/iden3/go-iden3-core/components/idenpuboffchain/writerhttp"
@@ -21,10 +33,13 @@ import (
 	"github.com/iden3/go-iden3-core/keystore"
 	"github.com/iden3/go-iden3-core/merkletree"
 	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/iden3/go-iden3-crypto/babyjub"
 	"github.com/iden3/go-iden3-servers-demo/servers/issuerdemo/messages"
 	issuerMsg "github.com/iden3/go-iden3-servers-demo/servers/issuerdemo/messages"
 	verifierMsg "github.com/iden3/go-iden3-servers-demo/servers/verifier/messages"
 	"github.com/iden3/go-iden3-servers/handlers"
+	"github.com/iden3/iden3-mobile/go/auth"
+	"github.com/iden3/iden3-mobile/go/relayer"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/go-playground/validator.v9"
@@ -42,20 +57,135 @@ func ShouldBindJSONValidate(c *gin.Context, v interface{}) error {
 	return nil
 }
 
+// Behaviour scripts how the issuer handles a claim request, to test the error
+// handling of the holder deterministically.
+type Behaviour string
+
+const (
+	// BehaviourApprove approves the request and issues the claim after
+	// TimeToAproveClaim. It's the default behaviour.
+	BehaviourApprove Behaviour = "approve"
+	// BehaviourReject rejects the request after TimeToAproveClaim.
+	BehaviourReject Behaviour = "reject"
+	// BehaviourStatusError responds to the claim status requests with an
+	// HTTP 500 error.
+	BehaviourStatusError Behaviour = "statusError"
+	// BehaviourMalformedJSON responds to the claim status requests with a
+	// body that is not valid JSON.
+	BehaviourMalformedJSON Behaviour = "malformedJSON"
+	// BehaviourSlow approves the request, but delays the responses to the
+	// claim status and credential requests by SlowResponse.
+	BehaviourSlow Behaviour = "slow"
+	// BehaviourNeverPublish approves the request, but the credential is never
+	// ready, as if the issuer state was never published.
+	BehaviourNeverPublish Behaviour = "neverPublish"
+	// BehaviourCredentialMismatch approves the request, but responds with the
+	// credential of a different claim.
+	BehaviourCredentialMismatch Behaviour = "credentialMismatch"
+	// BehaviourManual keeps the request pending until it's approved or
+	// rejected through the admin endpoints.
+	BehaviourManual Behaviour = "manual"
+)
+
+// ErrRequestNotPending is returned when resolving a request that has already
+// been approved or rejected.
+var ErrRequestNotPending = errors.New("The request is not pending")
+
 type Requests struct {
-	rw       sync.RWMutex
-	n        int
-	pending  map[int]issuerMsg.Request
-	approved map[int]issuerMsg.Request
-	// rejected map[int]issuerMsg.Request
+	rw sync.RWMutex
+	// resolving is held while a request is approved, rejected or revoked, so
+	// that the claim of a request is not issued or revoked twice
+	resolving  sync.Mutex
+	storage    db.Storage
+	n          int
+	pending    map[int]issuerMsg.Request
+	approved   map[int]issuerMsg.Request
+	rejected   map[int]issuerMsg.Request
+	behaviours map[int]Behaviour
+	// mismatches are the claims whose credential is sent instead of the
+	// approved claim in requests with BehaviourCredentialMismatch
+	mismatches map[int]*merkletree.Entry
+	// revoked are the approved requests whose claim has been revoked
+	revoked map[int]bool
+}
+
+// storedRequest is a request as it's kept in the storage.
+type storedRequest struct {
+	Request   issuerMsg.Request
+	Claim     *merkletree.Entry
+	Behaviour Behaviour
+	Mismatch  *merkletree.Entry
+	Revoked   bool
+}
+
+// AdminRequest is a request as it's listed by the admin endpoints.
+type AdminRequest struct {
+	issuerMsg.Request
+	Claim     *merkletree.Entry `json:"claim"`
+	Behaviour Behaviour         `json:"behaviour"`
+	Revoked   bool              `json:"revoked"`
+}
+
+// NewRequests loads the requests kept in storage.
+func NewRequests(storage db.Storage) (*Requests, error) {
+	r := &Requests{
+		storage:    storage,
+		n:          0,
+		pending:    make(map[int]issuerMsg.Request),
+		approved:   make(map[int]issuerMsg.Request),
+		rejected:   make(map[int]issuerMsg.Request),
+		behaviours: make(map[int]Behaviour),
+		mismatches: make(map[int]*merkletree.Entry),
+		revoked:    make(map[int]bool),
+	}
+	if err := storage.Iterate(func(key, value []byte) (bool, error) {
+		var stored storedRequest
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return false, err
+		}
+		request := stored.Request
+		request.Claim = stored.Claim
+		switch request.Status {
+		case issuerMsg.RequestStatusPending:
+			r.pending[request.Id] = request
+		case issuerMsg.RequestStatusApproved:
+			r.approved[request.Id] = request
+		case issuerMsg.RequestStatusRejected:
+			r.rejected[request.Id] = request
+		}
+		r.behaviours[request.Id] = stored.Behaviour
+		if stored.Mismatch != nil {
+			r.mismatches[request.Id] = stored.Mismatch
+		}
+		if stored.Revoked {
+			r.revoked[request.Id] = true
+		}
+		if request.Id > r.n {
+			r.n = request.Id
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
-func NewRequests() *Requests {
-	return &Requests{
-		n:        0,
-		pending:  make(map[int]issuerMsg.Request),
-		approved: make(map[int]issuerMsg.Request),
+// store keeps the request in the storage. The caller must hold the lock.
+func (r *Requests) store(request issuerMsg.Request) error {
+	tx, err := r.storage.NewTx()
+	if err != nil {
+		return err
+	}
+	if err := db.StoreJSON(tx, []byte(strconv.Itoa(request.Id)), storedRequest{
+		Request:   request,
+		Claim:     request.Claim,
+		Behaviour: r.behaviours[request.Id],
+		Mismatch:  r.mismatches[request.Id],
+		Revoked:   r.revoked[request.Id],
+	}); err != nil {
+		return err
 	}
+	return tx.Commit()
 }
 
 func (r *Requests) Approve(id int, claim merkletree.Entrier) error {
@@ -69,21 +199,36 @@ func (r *Requests) Approve(id int, claim merkletree.Entrier) error {
 	request.Claim = claim.Entry()
 	request.Status = issuerMsg.RequestStatusApproved
 	r.approved[id] = request
-	return nil
+	return r.store(request)
+}
+
+func (r *Requests) Reject(id int) error {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+	request, ok := r.pending[id]
+	if !ok {
+		return fmt.Errorf("Request id: %v not found", id)
+	}
+	delete(r.pending, id)
+	request.Status = issuerMsg.RequestStatusRejected
+	r.rejected[id] = request
+	return r.store(request)
 }
 
-func (r *Requests) Add(value, index string) int {
+func (r *Requests) Add(holderID *core.ID, index, value string, behaviour Behaviour) (int, error) {
 	r.rw.Lock()
 	defer r.rw.Unlock()
 	r.n += 1
 	request := messages.Request{
-		Id:     r.n,
-		Value:  value,
-		Index:  index,
-		Status: messages.RequestStatusPending,
+		Id:       r.n,
+		HolderID: holderID,
+		Value:    value,
+		Index:    index,
+		Status:   messages.RequestStatusPending,
 	}
 	r.pending[request.Id] = request
-	return r.n
+	r.behaviours[request.Id] = behaviour
+	return r.n, r.store(request)
 }
 
 func (r *Requests) Get(id int) (*messages.Request, error) {
@@ -95,9 +240,216 @@ func (r *Requests) Get(id int) (*messages.Request, error) {
 	if request, ok := r.approved[id]; ok {
 		return &request, nil
 	}
+	if request, ok := r.rejected[id]; ok {
+		return &request, nil
+	}
 	return nil, fmt.Errorf("Request id: %v not found", id)
 }
 
+// Resolve calls fn, that approves or rejects the request id, if the request
+// is still pending. No other request is resolved until fn returns.
+func (r *Requests) Resolve(id int, fn func(request *issuerMsg.Request) error) error {
+	r.resolving.Lock()
+	defer r.resolving.Unlock()
+	request, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	if request.Status != issuerMsg.RequestStatusPending {
+		return fmt.Errorf("Request id: %v: %w", id, ErrRequestNotPending)
+	}
+	return fn(request)
+}
+
+// List returns the requests with the given status sorted by id, or all of
+// them if status is empty.
+func (r *Requests) List(status issuerMsg.RequestStatus) []AdminRequest {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+	list := []AdminRequest{}
+	for _, requests := range []map[int]issuerMsg.Request{r.pending, r.approved, r.rejected} {
+		for id, request := range requests {
+			if status != "" && request.Status != status {
+				continue
+			}
+			list = append(list, AdminRequest{
+				Request:   request,
+				Claim:     request.Claim,
+				Behaviour: r.behaviours[id],
+				Revoked:   r.revoked[id],
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// Revoke calls fn, that revokes the claim of the request id, if the request is
+// approved and its claim not revoked yet, and then marks the claim as revoked.
+// No request is resolved or revoked until fn returns.
+func (r *Requests) Revoke(id int, fn func(request *issuerMsg.Request) error) error {
+	r.resolving.Lock()
+	defer r.resolving.Unlock()
+	request, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	if request.Status != issuerMsg.RequestStatusApproved {
+		return fmt.Errorf("Request id: %v is not approved", id)
+	}
+	if r.Revoked(id) {
+		return fmt.Errorf("Request id: %v is already revoked", id)
+	}
+	if err := fn(request); err != nil {
+		return err
+	}
+	r.rw.Lock()
+	defer r.rw.Unlock()
+	r.revoked[id] = true
+	return r.store(*request)
+}
+
+// Revoked returns true if the claim of the request id has been revoked.
+func (r *Requests) Revoked(id int) bool {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+	return r.revoked[id]
+}
+
+// Behaviour returns the behaviour of the request id.
+func (r *Requests) Behaviour(id int) Behaviour {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+	return r.behaviours[id]
+}
+
+// SetMismatch sets the claim whose credential is sent instead of the claim of
+// the request id.
+func (r *Requests) SetMismatch(id int, claim merkletree.Entrier) error {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+	r.mismatches[id] = claim.Entry()
+	request, ok := r.pending[id]
+	if !ok {
+		return fmt.Errorf("Request id: %v not found", id)
+	}
+	return r.store(request)
+}
+
+// GetByClaim returns the id of the approved request of the claim, its
+// behaviour and the claim whose credential is sent instead, if any.
+func (r *Requests) GetByClaim(claim *merkletree.Entry) (int, Behaviour, *merkletree.Entry, bool) {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+	for id, request := range r.approved {
+		if request.Claim.Equal(claim) {
+			return id, r.behaviours[id], r.mismatches[id], true
+		}
+	}
+	return 0, BehaviourApprove, nil, false
+}
+
+// claimRequestMaxAge is the maximum difference allowed between the timestamp of
+// a signed claim request and the time of the server.
+const claimRequestMaxAge = 5 * time.Minute
+
+// Nonces keeps the nonces of the signed requests received recently to avoid
+// the replay of requests.
+type Nonces struct {
+	rw     sync.Mutex
+	maxAge time.Duration
+	nonces map[string]int64
+}
+
+func NewNonces(maxAge time.Duration) *Nonces {
+	return &Nonces{
+		maxAge: maxAge,
+		nonces: make(map[string]int64),
+	}
+}
+
+// Add stores a nonce, failing if it has already been used.
+func (n *Nonces) Add(nonce string, timestamp int64) error {
+	n.rw.Lock()
+	defer n.rw.Unlock()
+	// Forget the nonces whose signatures would be rejected anyway due to their age
+	oldest := time.Now().Add(-n.maxAge).Unix()
+	for k, ts := range n.nonces {
+		if ts < oldest {
+			delete(n.nonces, k)
+		}
+	}
+	if _, ok := n.nonces[nonce]; ok {
+		return fmt.Errorf("Nonce %v already used", nonce)
+	}
+	n.nonces[nonce] = timestamp
+	return nil
+}
+
+// stateTransitionMaxAge is the maximum difference allowed between the timestamp
+// of a signed state transition or public data publication and the time of the
+// relayer.
+const stateTransitionMaxAge = 5 * time.Minute
+
+// PublicDatas keeps the off chain public data published by the identities
+// through the relayer.
+type PublicDatas struct {
+	storage db.Storage
+}
+
+func NewPublicDatas(storage db.Storage) *PublicDatas {
+	return &PublicDatas{
+		storage: storage,
+	}
+}
+
+// publicDataKey returns the key of the public data of an identity at state,
+// or of the last one published if state is nil.
+func publicDataKey(id *core.ID, state *merkletree.Hash) []byte {
+	if state == nil {
+		return append(id[:], []byte("laststate")...)
+	}
+	return append(id[:], state[:]...)
+}
+
+// Add stores the public data of an identity, after checking that the trees
+// match the identity state.
+func (p *PublicDatas) Add(id *core.ID, blobs *idenpuboffchain.PublicDataBlobs) error {
+	if _, err := idenpuboffchain.NewPublicDataFromBlobs(blobs); err != nil {
+		return err
+	}
+	tx, err := p.storage.NewTx()
+	if err != nil {
+		return err
+	}
+	if err := db.StoreJSON(tx, publicDataKey(id, &blobs.IdenState), blobs); err != nil {
+		return err
+	}
+	if err := db.StoreJSON(tx, publicDataKey(id, nil), blobs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns the public data of an identity at state, or the last one
+// published if state is nil.
+func (p *PublicDatas) Get(id *core.ID, state *merkletree.Hash) (*idenpuboffchain.PublicDataBlobs, error) {
+	var blobs idenpuboffchain.PublicDataBlobs
+	if err := db.LoadJSON(p.storage, publicDataKey(id, state), &blobs); err == db.ErrNotFound {
+		return nil, fmt.Errorf("Public data of %v not found", id)
+	} else if err != nil {
+		return nil, err
+	}
+	return &blobs, nil
+}
+
+// relayerTx returns a copy of tx that can be serialized in JSON, as the
+// transactions returned by the local IdenPubOnChain may lack the required
+// fields. The data, used to track the confirmations, is kept.
+func relayerTx(tx *types.Transaction) *types.Transaction {
+	return types.NewTransaction(0, common.Address{}, nil, 0, nil, tx.Data())
+}
+
 func newClaimDemo(id *core.ID, index, value []byte) claims.Claimer {
 	indexBytes, valueBytes := [claims.IndexSubjectSlotLen]byte{}, [claims.ValueSlotLen]byte{}
 	if len(index) > 248/8*2 || len(value) > 248/8*3 {
@@ -108,59 +460,469 @@ func newClaimDemo(id *core.ID, index, value []byte) claims.Claimer {
 	return claims.NewClaimOtherIden(id, indexBytes, valueBytes)
 }
 
+// claimTypeDemo is the default text at the start of the index of the issued claims
+const claimTypeDemo = "Mia kusenveturilo estas plena je angiloj."
+
+// defaultVerifierMaxAge is the default maximum age of the issuer state of the
+// credentials accepted by the verifiers
+const defaultVerifierMaxAge = 30 * time.Minute
+
+// IssuerConf is the configuration of an issuer of the mockup server.
+type IssuerConf struct {
+	// Prefix is the path prefix of the endpoints of the issuer, for example
+	// the claims are requested at /<Prefix>/claim/request.
+	Prefix            string        `yaml:"prefix"`
+	TimeToAproveClaim time.Duration `yaml:"timeToApproveClaim"`
+	TimeToPublish     time.Duration `yaml:"timeToPublish"`
+	// Behaviours scripts the handling of the claim requests by the data
+	// requested (the index and value of the request). The requests whose
+	// data is not in Behaviours have the DefaultBehaviour.
+	Behaviours map[string]Behaviour `yaml:"behaviours"`
+	// DefaultBehaviour is the behaviour of the claim requests whose data is
+	// not in Behaviours. If empty, they are approved.
+	DefaultBehaviour Behaviour `yaml:"defaultBehaviour"`
+	// SlowResponse is the delay of the responses to requests with BehaviourSlow
+	SlowResponse time.Duration `yaml:"slowResponse"`
+	// ClaimType is the text at the start of the index of the issued claims,
+	// so that issuers can issue different types of claims. If empty, the
+	// demo claim type is used.
+	ClaimType string `yaml:"claimType"`
+}
+
+// behaviour returns the behaviour of a claim request with the given data.
+func (cfg *IssuerConf) behaviour(data string) Behaviour {
+	if behaviour, ok := cfg.Behaviours[data]; ok {
+		return behaviour
+	}
+	if cfg.DefaultBehaviour != "" {
+		return cfg.DefaultBehaviour
+	}
+	return BehaviourApprove
+}
+
+func (cfg *IssuerConf) claimType() string {
+	if cfg.ClaimType == "" {
+		return claimTypeDemo
+	}
+	return cfg.ClaimType
+}
+
+// VerifierConf is the configuration of a verifier of the mockup server.
+type VerifierConf struct {
+	// Prefix is the path prefix of the endpoints of the verifier, for example
+	// the credentials are verified at /<Prefix>/verify.
+	Prefix string `yaml:"prefix"`
+	// MaxAge is the maximum age of the issuer state of the accepted
+	// credentials. If zero, it's 30 minutes.
+	MaxAge time.Duration `yaml:"maxAge"`
+	// Issuers are the prefixes of the issuers whose credentials are accepted
+	// (the empty prefix is the main issuer). If empty, the credentials of
+	// any issuer are accepted.
+	Issuers []string `yaml:"issuers"`
+}
+
+func (cfg *VerifierConf) maxAge() time.Duration {
+	if cfg.MaxAge == 0 {
+		return defaultVerifierMaxAge
+	}
+	return cfg.MaxAge
+}
+
+// Conf is the configuration of the mockup server. The fields of the main
+// issuer, served without prefix, are set in Conf, and the main verifier is
+// also served without prefix.
 type Conf struct {
-	IP                string
-	Port              string
-	TimeToAproveClaim time.Duration
-	TimeToPublish     time.Duration
+	// IP and Port are the address where the server listens.
+	IP   string `yaml:"ip"`
+	Port string `yaml:"port"`
+	// PublicUrl is the base url of the server in the urls sent to the
+	// clients, like the url of the public data of the issuers. If empty, it's
+	// http://<IP>:<Port>/, which requires IP to be reachable by the clients.
+	PublicUrl         string        `yaml:"publicUrl"`
+	TimeToAproveClaim time.Duration `yaml:"timeToApproveClaim"`
+	TimeToPublish     time.Duration `yaml:"timeToPublish"`
+	// Behaviours scripts the handling of the claim requests by the data
+	// requested (the index and value of the request). The requests whose
+	// data is not in Behaviours have the DefaultBehaviour.
+	Behaviours map[string]Behaviour `yaml:"behaviours"`
+	// DefaultBehaviour is the behaviour of the claim requests whose data is
+	// not in Behaviours. If empty, they are approved.
+	DefaultBehaviour Behaviour `yaml:"defaultBehaviour"`
+	// SlowResponse is the delay of the responses to requests with BehaviourSlow
+	SlowResponse time.Duration `yaml:"slowResponse"`
+	// Issuers are additional issuers served under their prefix.
+	Issuers []IssuerConf `yaml:"issuers"`
+	// Verifiers are additional verifiers served under their prefix.
+	Verifiers []VerifierConf `yaml:"verifiers"`
+	// AdminToken is the token required in the Authorization header (as
+	// "Bearer <AdminToken>") by the admin endpoints. If empty, the admin
+	// endpoints only accept requests from localhost.
+	AdminToken string `yaml:"adminToken"`
+	// StorePath is the directory where the issuers, their keystore, the claim
+	// requests and the public data published through the relayer are stored,
+	// so that they survive restarts. If empty, they are kept in memory.
+	StorePath string `yaml:"storePath"`
+}
+
+// publicUrl returns the base url of the server sent to the clients, ending
+// with "/".
+func (cfg *Conf) publicUrl() (string, error) {
+	if cfg.PublicUrl == "" {
+		if ip := net.ParseIP(cfg.IP); cfg.IP == "" || ip != nil && ip.IsUnspecified() {
+			return "", fmt.Errorf("publicUrl is required when listening on %q", cfg.IP)
+		}
+		return fmt.Sprintf("http://%v:%v/", cfg.IP, cfg.Port), nil
+	}
+	if _, err := url.ParseRequestURI(cfg.PublicUrl); err != nil {
+		return "", fmt.Errorf("Invalid publicUrl: %w", err)
+	}
+	return strings.TrimSuffix(cfg.PublicUrl, "/") + "/", nil
+}
+
+// mainIssuer returns the configuration of the main issuer.
+func (cfg *Conf) mainIssuer() IssuerConf {
+	return IssuerConf{
+		TimeToAproveClaim: cfg.TimeToAproveClaim,
+		TimeToPublish:     cfg.TimeToPublish,
+		Behaviours:        cfg.Behaviours,
+		DefaultBehaviour:  cfg.DefaultBehaviour,
+		SlowResponse:      cfg.SlowResponse,
+	}
+}
+
+// Prefixes of the storage of the mockup server
+const (
+	issuerPrefix          = "issuer"
+	idenPubOffChainPrefix = "idenpuboffchain"
+	requestsPrefix        = "requests"
+	publicDatasPrefix     = "publicdatas"
+	issuerKOpStorKey      = "kOp"
+	// idenPubOffChainConfigKey is the key where the writer of the public data
+	// stores its config
+	idenPubOffChainConfigKey = "config"
+	// prefixedIssuersPrefix is the prefix of the storage of the issuers with
+	// prefix, followed by their prefix
+	prefixedIssuersPrefix = "prefixedissuers/"
+)
+
+// openStorage opens the storage and keystore storage at storePath, or in
+// memory if storePath is empty.
+func openStorage(storePath string) (db.Storage, keystore.Storage, error) {
+	if storePath == "" {
+		ksStorage := keystore.MemStorage([]byte{})
+		return db.NewMemoryStorage(), &ksStorage, nil
+	}
+	if err := os.MkdirAll(storePath, 0700); err != nil {
+		return nil, nil, err
+	}
+	storage, err := db.NewLevelDbStorage(path.Join(storePath, "db"), false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening leveldb storage: %w", err)
+	}
+	return storage, keystore.NewFileStorage(path.Join(storePath, "keystore")), nil
 }
 
 func NewIssuer(t *testing.T, idenPubOnChain idenpubonchain.IdenPubOnChainer,
 	idenPubOffChainWrite idenpuboffchain.IdenPubOffChainWriter,
 	zkFilesIdenState *zkutils.ZkFiles,
 ) *issuer.Issuer {
-	cfg := issuer.ConfigDefault
-	storage := db.NewMemoryStorage()
 	ksStorage := keystore.MemStorage([]byte{})
 	keyStore, err := keystore.NewKeyStore(&ksStorage, keystore.LightKeyStoreParams)
 	require.Nil(t, err)
-	kOp, err := keyStore.NewKey([]byte("pass"))
-	require.Nil(t, err)
-	err = keyStore.UnlockKey(kOp, []byte("pass"))
-	require.Nil(t, err)
-	_, err = issuer.Create(cfg, kOp, []claims.Claimer{}, storage, keyStore)
-	require.Nil(t, err)
+	is, err := newIssuer(db.NewMemoryStorage(), keyStore, idenPubOnChain, idenPubOffChainWrite, zkFilesIdenState)
 	require.Nil(t, err)
+	return is
+}
+
+// newIssuer loads the issuer kept in storage, creating it the first time.
+func newIssuer(storage db.Storage, keyStore *keystore.KeyStore,
+	idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	idenPubOffChainWrite idenpuboffchain.IdenPubOffChainWriter,
+	zkFilesIdenState *zkutils.ZkFiles,
+) (*issuer.Issuer, error) {
+	cfg := issuer.ConfigDefault
+	kOp := &babyjub.PublicKeyComp{}
+	if err := db.LoadJSON(storage, []byte(issuerKOpStorKey), kOp); err == db.ErrNotFound {
+		// Create the issuer
+		kOp, err = keyStore.NewKey([]byte("pass"))
+		if err != nil {
+			return nil, err
+		}
+		if err := keyStore.UnlockKey(kOp, []byte("pass")); err != nil {
+			return nil, err
+		}
+		if _, err := issuer.Create(cfg, kOp, []claims.Claimer{}, storage.WithPrefix([]byte(issuerPrefix)), keyStore); err != nil {
+			return nil, err
+		}
+		tx, err := storage.NewTx()
+		if err != nil {
+			return nil, err
+		}
+		if err := db.StoreJSON(tx, []byte(issuerKOpStorKey), kOp); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if err := keyStore.UnlockKey(kOp, []byte("pass")); err != nil {
+		return nil, err
+	}
 	idenStateZkProofConf := &issuer.IdenStateZkProofConf{
 		Levels: 16,
 		Files:  *zkFilesIdenState,
 	}
-	is, err := issuer.Load(
-		storage,
+	return issuer.Load(
+		storage.WithPrefix([]byte(issuerPrefix)),
 		keyStore,
 		idenPubOnChain,
 		idenStateZkProofConf,
 		idenPubOffChainWrite,
 	)
-	require.Nil(t, err)
-	return is
 }
 
+// Server is a running mockup server.
+type Server struct {
+	*http.Server
+	storage  db.Storage
+	keyStore *keystore.KeyStore
+	// stop is closed on shutdown to stop the background work of the
+	// issuers, and workers counts it
+	stop     chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+	// adminToken is the token required by the admin endpoints, or empty if
+	// they are only served to localhost
+	adminToken string
+}
+
+// authAdmin aborts the requests to the admin endpoints without the admin
+// token, or not coming from localhost if there is no admin token.
+func (s *Server) authAdmin(c *gin.Context) {
+	if s.adminToken != "" {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid admin token"})
+		}
+		return
+	}
+	// The remote address is used instead of c.ClientIP(), which trusts the
+	// X-Forwarded-For header
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		c.AbortWithStatusJSON(403, gin.H{"error": "the admin endpoints are only served to localhost"})
+	}
+}
+
+// Shutdown gracefully stops serving, waits for the background work of the
+// issuers and closes the storage, so that the server can be started again on
+// the same StorePath.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	s.release()
+	return err
+}
+
+// Close is like Shutdown, but closes the active connections immediately.
+func (s *Server) Close() error {
+	err := s.Server.Close()
+	s.release()
+	return err
+}
+
+// release stops the background work of the issuers and closes the storage.
+func (s *Server) release() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.workers.Wait()
+		if err := s.keyStore.Close(); err != nil {
+			log.WithError(err).Error("keyStore.Close()")
+		}
+		s.storage.Close()
+	})
+}
+
+// sleep waits for d, and returns false if the server is shut down before.
+func (s *Server) sleep(d time.Duration) bool {
+	select {
+	case <-s.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Serve is like Start, but fails the test t on error.
 func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChainer,
 	zkFilesIdenState *zkutils.ZkFiles,
 	zkFilesCredential *zkutils.ZkFiles,
-) *http.Server {
-	idenPubOffChainWrite, err := idenpuboffchainwriterhttp.NewIdenPubOffChainWriteHttp(
-		idenpuboffchainwriterhttp.NewConfigDefault(fmt.Sprintf("http://%v:%v/idenpublicdata/", cfg.IP, cfg.Port)),
-		db.NewMemoryStorage(),
-	)
+) *Server {
+	server, err := Start(cfg, idenPubOnChain, zkFilesIdenState, zkFilesCredential)
 	require.Nil(t, err)
-	is := NewIssuer(t, idenPubOnChain, idenPubOffChainWrite, zkFilesIdenState)
-	requests := NewRequests()
-	verif := verifier.New(idenPubOnChain)
+	return server
+}
+
+// Start starts serving the mockup issuers, verifiers and relayer in the
+// background, and returns the server so that it can be shut down.
+func Start(cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	zkFilesIdenState *zkutils.ZkFiles,
+	zkFilesCredential *zkutils.ZkFiles,
+) (*Server, error) {
+	storage, ksStorage, err := openStorage(cfg.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	keyStore, err := keystore.NewKeyStore(ksStorage, keystore.LightKeyStoreParams)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+	s := &Server{
+		storage:    storage,
+		keyStore:   keyStore,
+		stop:       make(chan struct{}),
+		adminToken: cfg.AdminToken,
+	}
+	started := false
+	defer func() {
+		if !started {
+			s.release()
+		}
+	}()
+
+	api := gin.Default()
+	api.NoRoute(func(c *gin.Context) {
+		c.JSON(404, gin.H{
+			"error": "404 page not found",
+		})
+	})
+
+	// ISSUER ENDPOINTS
+
+	baseUrl, err := cfg.publicUrl()
+	if err != nil {
+		return nil, err
+	}
+	mainIssuer := cfg.mainIssuer()
+	issuers := make(map[string]*issuer.Issuer)
+	for i, issuerCfg := range append([]IssuerConf{mainIssuer}, cfg.Issuers...) {
+		issuerCfg := issuerCfg
+		issuerStorage := storage
+		if i > 0 {
+			if issuerCfg.Prefix == "" {
+				return nil, fmt.Errorf("Issuer %v has no prefix", i)
+			}
+			issuerStorage = storage.WithPrefix([]byte(prefixedIssuersPrefix + issuerCfg.Prefix + "/"))
+		}
+		if _, ok := issuers[issuerCfg.Prefix]; ok {
+			return nil, fmt.Errorf("Duplicated issuer prefix %v", issuerCfg.Prefix)
+		}
+		is, err := s.serveIssuer(api.Group("/"+issuerCfg.Prefix), &issuerCfg, baseUrl+prefixPath(issuerCfg.Prefix),
+			issuerStorage, keyStore, idenPubOnChain, zkFilesIdenState)
+		if err != nil {
+			return nil, err
+		}
+		issuers[issuerCfg.Prefix] = is
+	}
+
+	// RELAYER ENDPOINTS
+
+	serveRelayer(api.Group("/relayer"), storage, idenPubOnChain, zkFilesIdenState)
+
+	// VERIFIER ENDPOINTS
+
+	verifierPrefixes := make(map[string]bool)
+	for _, verifierCfg := range append([]VerifierConf{{}}, cfg.Verifiers...) {
+		verifierCfg := verifierCfg
+		if verifierPrefixes[verifierCfg.Prefix] {
+			return nil, fmt.Errorf("Duplicated verifier prefix %v", verifierCfg.Prefix)
+		}
+		verifierPrefixes[verifierCfg.Prefix] = true
+		if err := serveVerifier(api.Group("/"+verifierCfg.Prefix), &verifierCfg, issuers,
+			idenPubOnChain, zkFilesCredential); err != nil {
+			return nil, err
+		}
+	}
+
+	s.Server = &http.Server{Addr: fmt.Sprintf("%v:%v", cfg.IP, cfg.Port), Handler: api}
+
+	go func() {
+		if err := ListenAndServe(s.Server, "Service"); err != nil &&
+			err != http.ErrServerClosed {
+			log.Fatalf("listen: %s\n", err)
+		}
+	}()
+	started = true
+	return s, nil
+}
+
+// prefixPath returns the path of the endpoints under prefix.
+func prefixPath(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// loadIdenPubOffChainWrite loads the writer of the public data kept in
+// storage, or creates it if there is none. The stored url is replaced by
+// publicDataUrl if they differ, so that a change of the public url of the
+// server applies to the data published from then on.
+func loadIdenPubOffChainWrite(storage db.Storage,
+	publicDataUrl string) (*idenpuboffchainwriterhttp.IdenPubOffChainWriteHttp, error) {
+	var cfg idenpuboffchainwriterhttp.Config
+	err := db.LoadJSON(storage, []byte(idenPubOffChainConfigKey), &cfg)
+	if err == db.ErrNotFound {
+		return idenpuboffchainwriterhttp.NewIdenPubOffChainWriteHttp(
+			idenpuboffchainwriterhttp.NewConfigDefault(publicDataUrl), storage)
+	} else if err != nil {
+		return nil, err
+	}
+	if cfg.Url != publicDataUrl {
+		// NewIdenPubOffChainWriteHttp would reset the cache of the published
+		// data, so only the config is replaced
+		cfg.Url = publicDataUrl
+		tx, err := storage.NewTx()
+		if err != nil {
+			return nil, err
+		}
+		if err := db.StoreJSON(tx, []byte(idenPubOffChainConfigKey), &cfg); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return idenpuboffchainwriterhttp.LoadIdenPubOffChainWriteHttp(storage)
+}
+
+// serveIssuer loads the issuer kept in storage and serves its endpoints in
+// api. baseUrl is the url of api.
+func (s *Server) serveIssuer(api *gin.RouterGroup, cfg *IssuerConf, baseUrl string,
+	storage db.Storage, keyStore *keystore.KeyStore,
+	idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	zkFilesIdenState *zkutils.ZkFiles,
+) (*issuer.Issuer, error) {
+	idenPubOffChainWrite, err := loadIdenPubOffChainWrite(storage.WithPrefix([]byte(idenPubOffChainPrefix)),
+		baseUrl+"idenpublicdata/")
+	if err != nil {
+		return nil, err
+	}
+	is, err := newIssuer(storage, keyStore, idenPubOnChain, idenPubOffChainWrite, zkFilesIdenState)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := NewRequests(storage.WithPrefix([]byte(requestsPrefix)))
+	if err != nil {
+		return nil, err
+	}
+	nonces := NewNonces(claimRequestMaxAge)
 
-	// Publish and sync issuer state every 2 seconds
+	// Publish and sync issuer state every cfg.TimeToPublish
+	s.workers.Add(1)
 	go func() {
+		defer s.workers.Done()
 		for {
 			err := is.PublishState()
 			if err != nil {
@@ -172,42 +934,112 @@ func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChain
 			if err != nil {
 				log.WithError(err).Error("Issuer.SyncIdenStatePublic()")
 			}
-			time.Sleep(cfg.TimeToPublish)
+			if !s.sleep(cfg.TimeToPublish) {
+				return
+			}
 		}
 	}()
 
-	api := gin.Default()
-	api.NoRoute(func(c *gin.Context) {
-		c.JSON(404, gin.H{
-			"error": "404 page not found",
+	// issue issues the claim of the pending request id and approves it. The
+	// claim can't be approved before it's issued, because the issuer sets its
+	// revocation nonce, so if the approval fails the issued claims are revoked.
+	// Then the claim can't be issued again, and the request can only be
+	// rejected.
+	issue := func(id int, request *issuerMsg.Request) (err error) {
+		claim := newClaimDemo(request.HolderID,
+			append([]byte(cfg.claimType()), []byte(request.Index)...),
+			[]byte(request.Value))
+		var issued []claims.Claimer
+		defer func() {
+			if err == nil {
+				return
+			}
+			for _, claim := range issued {
+				if err := is.RevokeClaim(claim); err != nil {
+					log.WithError(err).WithField("id", id).Error("Issuer.RevokeClaim() of the claim not approved")
+				}
+			}
+		}()
+
+		// Issue Claim
+		if err := is.IssueClaim(claim); err != nil {
+			return err
+		}
+		issued = append(issued, claim)
+		if requests.Behaviour(id) == BehaviourCredentialMismatch {
+			mismatch := newClaimDemo(request.HolderID,
+				append([]byte("Mia kusenveturilo ne estas plena je angiloj."), []byte(request.Index)...),
+				[]byte(request.Value))
+			if err := is.IssueClaim(mismatch); err != nil {
+				return err
+			}
+			issued = append(issued, mismatch)
+			if err := requests.SetMismatch(id, mismatch); err != nil {
+				return err
+			}
+		}
+		return requests.Approve(id, claim)
+	}
+	// approve and reject resolve the request id, or fail with
+	// ErrRequestNotPending if it has already been resolved
+	approve := func(id int) error {
+		return requests.Resolve(id, func(request *issuerMsg.Request) error {
+			return issue(id, request)
 		})
-	})
+	}
+	reject := func(id int) error {
+		return requests.Resolve(id, func(*issuerMsg.Request) error {
+			return requests.Reject(id)
+		})
+	}
 
 	// ISSUER ENDPOINTS
 
 	api.POST("/claim/request", func(c *gin.Context) {
-		var req issuerMsg.ReqClaimRequest
+		var req auth.ReqClaimRequest
 		if err := ShouldBindJSONValidate(c, &req); err != nil {
 			return
 		}
-		id := requests.Add(req.Index, req.Value)
-		// Approve request and issue claim after c.TimeToAproveClaim duration
+		// Check that the request has been signed by the holder of the ID
+		if err := req.Signature.Verify(auth.DomainClaimRequest, req.HolderID,
+			auth.ClaimRequestPayload(&req.ReqClaimRequest), time.Now(), claimRequestMaxAge); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		if err := nonces.Add(req.Signature.Nonce, req.Signature.Timestamp); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		behaviour := cfg.behaviour(req.Value)
+		id, err := requests.Add(req.HolderID, req.Index, req.Value, behaviour)
+		if err != nil {
+			handlers.Fail(c, "Requests.Add()", err)
+			return
+		}
+		if behaviour == BehaviourManual {
+			// Wait for the admin to approve or reject the request
+			c.JSON(200, issuerMsg.ResClaimRequest{
+				Id: id,
+			})
+			return
+		}
+		// Approve request and issue claim after c.TimeToAproveClaim duration.
+		// If the server is shut down before, the request stays pending.
+		s.workers.Add(1)
 		go func() {
-			time.Sleep(cfg.TimeToAproveClaim)
-
-			claim := newClaimDemo(req.HolderID,
-				append([]byte("Mia kusenveturilo estas plena je angiloj."), []byte(req.Index)...),
-				[]byte(req.Value))
-
-			// Issue Claim
-			if err := is.IssueClaim(claim); err != nil {
-				log.WithError(err).WithField("value", req.Value).Error("SRV Issuer.IssueClaim()")
+			defer s.workers.Done()
+			if !s.sleep(cfg.TimeToAproveClaim) {
 				return
+			}
 
+			if behaviour == BehaviourReject {
+				if err := reject(id); err != nil {
+					log.WithError(err).WithField("value", req.Value).Info("SRV reject()")
+				}
+				return
 			}
-			err := requests.Approve(id, claim)
-			if err != nil {
-				log.WithError(err).WithField("value", req.Value).Info("SRV requests.Approve()")
+			if err := approve(id); err != nil {
+				log.WithError(err).WithField("value", req.Value).Error("SRV approve()")
 			}
 		}()
 		c.JSON(200, issuerMsg.ResClaimRequest{
@@ -223,6 +1055,16 @@ func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChain
 			handlers.Fail(c, "cannot validate uri", err)
 			return
 		}
+		switch requests.Behaviour(uri.Id) {
+		case BehaviourStatusError:
+			c.JSON(500, gin.H{"error": "scripted internal server error"})
+			return
+		case BehaviourMalformedJSON:
+			c.Data(200, "application/json", []byte(`{"status": "approved", "claim": `))
+			return
+		case BehaviourSlow:
+			time.Sleep(cfg.SlowResponse)
+		}
 		request, err := requests.Get(uri.Id)
 		if err != nil {
 			handlers.Fail(c, "Requests.Get()", err)
@@ -239,8 +1081,22 @@ func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChain
 		if err := ShouldBindJSONValidate(c, &req); err != nil {
 			return
 		}
+		var claim claims.Claimer = claims.NewClaimGeneric(req.Claim)
+		if _, behaviour, mismatch, ok := requests.GetByClaim(req.Claim); ok {
+			switch behaviour {
+			case BehaviourSlow:
+				time.Sleep(cfg.SlowResponse)
+			case BehaviourNeverPublish:
+				c.JSON(200, issuerMsg.ResClaimCredential{
+					Status: issuerMsg.ClaimtStatusNotYet,
+				})
+				return
+			case BehaviourCredentialMismatch:
+				claim = claims.NewClaimGeneric(mismatch)
+			}
+		}
 		// Generate Credential Existence
-		credential, err := is.GenCredentialExistence(claims.NewClaimGeneric(req.Claim))
+		credential, err := is.GenCredentialExistence(claim)
 		status := issuerMsg.ClaimtStatusReady
 		if err == issuer.ErrClaimNotYetInOnChainState {
 			log.Debug("Issuer.GenCredentialExistence -> ErrClaimNotYetInOnChainState")
@@ -289,7 +1145,231 @@ func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChain
 		_handleGetIdenPublicData(c, &state)
 	})
 
-	// VERIFIER ENDPOINTS
+	adminApi := api.Group("/admin", s.authAdmin)
+
+	_bindRequestId := func(c *gin.Context) (int, error) {
+		var uri struct {
+			Id int `uri:"id"`
+		}
+		if err := c.ShouldBindUri(&uri); err != nil {
+			handlers.Fail(c, "cannot validate uri", err)
+			return 0, err
+		}
+		return uri.Id, nil
+	}
+
+	adminApi.POST("/claim/approve/:id", func(c *gin.Context) {
+		id, err := _bindRequestId(c)
+		if err != nil {
+			return
+		}
+		if err := approve(id); errors.Is(err, ErrRequestNotPending) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			handlers.Fail(c, "approve()", err)
+			return
+		}
+		c.JSON(200, gin.H{})
+	})
+
+	adminApi.POST("/claim/reject/:id", func(c *gin.Context) {
+		id, err := _bindRequestId(c)
+		if err != nil {
+			return
+		}
+		if err := reject(id); errors.Is(err, ErrRequestNotPending) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			handlers.Fail(c, "reject()", err)
+			return
+		}
+		c.JSON(200, gin.H{})
+	})
+
+	adminApi.GET("/claim/requests", func(c *gin.Context) {
+		var query struct {
+			Status issuerMsg.RequestStatus `form:"status"`
+		}
+		if err := c.ShouldBindQuery(&query); err != nil {
+			handlers.Fail(c, "cannot validate query", err)
+			return
+		}
+		c.JSON(200, requests.List(query.Status))
+	})
+
+	adminApi.POST("/claim/revoke/:id", func(c *gin.Context) {
+		id, err := _bindRequestId(c)
+		if err != nil {
+			return
+		}
+		if err := requests.Revoke(id, func(request *issuerMsg.Request) error {
+			return is.RevokeClaim(claims.NewClaimGeneric(request.Claim))
+		}); err != nil {
+			handlers.Fail(c, "cannot revoke the claim", err)
+			return
+		}
+		// Publish the new state now, or in the next publication if there's
+		// a state transition in progress
+		if err := is.PublishState(); err != nil && err != issuer.ErrIdenStatePendingNotNil {
+			handlers.Fail(c, "Issuer.PublishState()", err)
+			return
+		}
+		c.JSON(200, gin.H{})
+	})
+	return is, nil
+}
+
+// serveRelayer serves the endpoints of the relayer in api.
+func serveRelayer(api *gin.RouterGroup, storage db.Storage,
+	idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	zkFilesIdenState *zkutils.ZkFiles,
+) {
+	relayerNonces := NewNonces(stateTransitionMaxAge)
+	publicDatas := NewPublicDatas(storage.WithPrefix([]byte(publicDatasPrefix)))
+
+	api.POST(relayer.PathIdenStateInit, func(c *gin.Context) {
+		var req relayer.ReqInitState
+		if err := ShouldBindJSONValidate(c, &req); err != nil {
+			return
+		}
+		if err := req.Signature.Verify(auth.DomainStateTransition, req.ID,
+			relayer.StateTransitionPayload(req.NewState), time.Now(), stateTransitionMaxAge); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		if err := relayerNonces.Add(req.Signature.Nonce, req.Signature.Timestamp); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		tx, err := idenPubOnChain.InitState(req.ID, req.GenesisState, req.NewState, req.Proof)
+		if err != nil {
+			handlers.Fail(c, "IdenPubOnChain.InitState()", err)
+			return
+		}
+		c.JSON(200, relayer.ResState{Tx: relayerTx(tx)})
+	})
+
+	api.POST(relayer.PathIdenStateSet, func(c *gin.Context) {
+		var req relayer.ReqSetState
+		if err := ShouldBindJSONValidate(c, &req); err != nil {
+			return
+		}
+		if err := req.Signature.Verify(auth.DomainStateTransition, req.ID,
+			relayer.StateTransitionPayload(req.NewState), time.Now(), stateTransitionMaxAge); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		if err := relayerNonces.Add(req.Signature.Nonce, req.Signature.Timestamp); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		tx, err := idenPubOnChain.SetState(req.ID, req.NewState, req.Proof)
+		if err != nil {
+			handlers.Fail(c, "IdenPubOnChain.SetState()", err)
+			return
+		}
+		c.JSON(200, relayer.ResState{Tx: relayerTx(tx)})
+	})
+
+	_bindIdenPublicDataId := func(c *gin.Context) (*core.ID, error) {
+		var uri struct {
+			Id string `uri:"id"`
+		}
+		if err := c.ShouldBindUri(&uri); err != nil {
+			handlers.Fail(c, "cannot validate uri", err)
+			return nil, err
+		}
+		id, err := core.IDFromString(uri.Id)
+		if err != nil {
+			handlers.Fail(c, "cannot parse id", err)
+			return nil, err
+		}
+		return &id, nil
+	}
+
+	api.POST(relayer.PathIdenPublicData+"/:id", func(c *gin.Context) {
+		id, err := _bindIdenPublicDataId(c)
+		if err != nil {
+			return
+		}
+		var req relayer.ReqPublicData
+		if err := ShouldBindJSONValidate(c, &req); err != nil {
+			return
+		}
+		// Only the identity can replace its last published data
+		if err := req.Signature.Verify(auth.DomainPublicData, id,
+			relayer.PublicDataPayload(&req.PublicData.IdenState), time.Now(), stateTransitionMaxAge); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		if err := relayerNonces.Add(req.Signature.Nonce, req.Signature.Timestamp); err != nil {
+			handlers.Fail(c, "invalid signature", err)
+			return
+		}
+		if err := publicDatas.Add(id, req.PublicData); err != nil {
+			handlers.Fail(c, "PublicDatas.Add()", err)
+			return
+		}
+		c.JSON(200, gin.H{})
+	})
+
+	_handleGetRelayerPublicData := func(c *gin.Context, id *core.ID, state *merkletree.Hash) {
+		data, err := publicDatas.Get(id, state)
+		if err != nil {
+			handlers.Fail(c, "PublicDatas.Get()", err)
+			return
+		}
+		c.JSON(200, data)
+	}
+
+	api.GET(relayer.PathIdenPublicData+"/:id/laststate", func(c *gin.Context) {
+		id, err := _bindIdenPublicDataId(c)
+		if err != nil {
+			return
+		}
+		_handleGetRelayerPublicData(c, id, nil)
+	})
+
+	api.GET(relayer.PathIdenPublicData+"/:id/state/:state", func(c *gin.Context) {
+		id, err := _bindIdenPublicDataId(c)
+		if err != nil {
+			return
+		}
+		var state merkletree.Hash
+		if err := state.UnmarshalText([]byte(c.Param("state"))); err != nil {
+			handlers.Fail(c, "cannot unmarshal state", err)
+			return
+		}
+		_handleGetRelayerPublicData(c, id, &state)
+	})
+
+	api.Static(relayer.PathIdStateArtifacts, zkFilesIdenState.Path)
+}
+
+// serveVerifier serves the endpoints of a verifier in api. issuers are the
+// issuers of the server by prefix.
+func serveVerifier(api *gin.RouterGroup, cfg *VerifierConf, issuers map[string]*issuer.Issuer,
+	idenPubOnChain idenpubonchain.IdenPubOnChainer,
+	zkFilesCredential *zkutils.ZkFiles,
+) error {
+	verif := verifier.New(idenPubOnChain)
+	trusted := make(map[core.ID]bool)
+	for _, prefix := range cfg.Issuers {
+		is, ok := issuers[prefix]
+		if !ok {
+			return fmt.Errorf("Verifier %v trusts an unknown issuer: %v", cfg.Prefix, prefix)
+		}
+		trusted[*is.ID()] = true
+	}
+	// checkIssuer checks that the credentials of the issuer are accepted
+	checkIssuer := func(id *core.ID) error {
+		if len(trusted) > 0 && !trusted[*id] {
+			return fmt.Errorf("The issuer %v is not trusted", id)
+		}
+		return nil
+	}
 
 	api.POST("/verify", func(c *gin.Context) {
 		var req verifierMsg.ReqVerify
@@ -297,11 +1377,15 @@ func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChain
 			handlers.Fail(c, "cannot parse json body", err)
 			return
 		}
-		err := verif.VerifyCredentialValidity(req.CredentialValidity, 30*time.Minute)
+		err := verif.VerifyCredentialValidity(req.CredentialValidity, cfg.maxAge())
 		if err != nil {
 			handlers.Fail(c, "VerifyCredentialValidity()", err)
 			return
 		}
+		if err := checkIssuer(req.CredentialValidity.CredentialExistence.Id); err != nil {
+			handlers.Fail(c, "untrusted issuer", err)
+			return
+		}
 
 		c.JSON(200, gin.H{})
 	})
@@ -321,25 +1405,20 @@ func Serve(t *testing.T, cfg *Conf, idenPubOnChain idenpubonchain.IdenPubOnChain
 			req.IssuerID,
 			req.IdenStateBlockN,
 			zkFilesCredential,
-			30*time.Minute,
+			cfg.maxAge(),
 		)
 		if err != nil {
 			handlers.Fail(c, "VerifyZkProofCredential()", err)
 			return
 		}
+		if err := checkIssuer(req.IssuerID); err != nil {
+			handlers.Fail(c, "untrusted issuer", err)
+			return
+		}
 
 		c.JSON(200, gin.H{})
 	})
-
-	server := &http.Server{Addr: fmt.Sprintf("%v:%v", cfg.IP, cfg.Port), Handler: api}
-
-	go func() {
-		if err := ListenAndServe(server, "Service"); err != nil &&
-			err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
-		}
-	}()
-	return server
+	return nil
 }
 
 type tcpKeepAliveListener struct {
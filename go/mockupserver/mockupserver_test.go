@@ -0,0 +1,130 @@
+package mockupserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/core/claims"
+	"github.com/iden3/go-iden3-core/db"
+	issuerMsg "github.com/iden3/go-iden3-servers-demo/servers/issuerdemo/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestsResolve(t *testing.T) {
+	requests, err := NewRequests(db.NewMemoryStorage())
+	require.Nil(t, err)
+	holderID := core.NewID(core.TypeBJP0, [27]byte{1})
+	id, err := requests.Add(&holderID, "index", "value", BehaviourManual)
+	require.Nil(t, err)
+
+	// The request is approved once, no matter how many approvals race
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	issued, notPending := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := requests.Resolve(id, func(request *issuerMsg.Request) error {
+				m.Lock()
+				issued++
+				m.Unlock()
+				return requests.Approve(id, claims.NewClaimBasic([claims.IndexSlotLen]byte{1},
+					[claims.ValueSlotLen]byte{2}))
+			})
+			m.Lock()
+			defer m.Unlock()
+			if errors.Is(err, ErrRequestNotPending) {
+				notPending++
+			} else {
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, issued)
+	require.Equal(t, 7, notPending)
+	request, err := requests.Get(id)
+	require.Nil(t, err)
+	require.Equal(t, issuerMsg.RequestStatusApproved, request.Status)
+
+	err = requests.Resolve(id, func(*issuerMsg.Request) error { return requests.Reject(id) })
+	require.True(t, errors.Is(err, ErrRequestNotPending))
+	err = requests.Resolve(id+1, func(*issuerMsg.Request) error { return nil })
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrRequestNotPending))
+}
+
+func TestRequestsRevoke(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	requests, err := NewRequests(storage)
+	require.Nil(t, err)
+	holderID := core.NewID(core.TypeBJP0, [27]byte{1})
+	id, err := requests.Add(&holderID, "index", "value", BehaviourManual)
+	require.Nil(t, err)
+	revoke := func(*issuerMsg.Request) error { return nil }
+
+	// Only the claims of approved requests are revoked
+	require.Error(t, requests.Revoke(id, revoke))
+	require.Nil(t, requests.Approve(id, claims.NewClaimBasic([claims.IndexSlotLen]byte{1},
+		[claims.ValueSlotLen]byte{2})))
+	errRevoke := errors.New("revoke error")
+	require.Equal(t, errRevoke, requests.Revoke(id, func(*issuerMsg.Request) error { return errRevoke }))
+	require.False(t, requests.Revoked(id))
+
+	// The claim is revoked once, no matter how many revocations race
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	revoked := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = requests.Revoke(id, func(*issuerMsg.Request) error {
+				m.Lock()
+				defer m.Unlock()
+				revoked++
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, revoked)
+	requests, err = NewRequests(storage)
+	require.Nil(t, err)
+	require.True(t, requests.Revoked(id))
+}
+
+func TestServerAuthAdmin(t *testing.T) {
+	status := func(s *Server, remoteAddr, authorization string) int {
+		w := httptest.NewRecorder()
+		_, engine := gin.CreateTestContext(w)
+		engine.GET("/admin", s.authAdmin, func(c *gin.Context) { c.JSON(200, gin.H{}) })
+		req := httptest.NewRequest("GET", "/admin", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "127.0.0.1")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Without admin token, only the requests from localhost are accepted
+	s := &Server{}
+	require.Equal(t, http.StatusOK, status(s, "127.0.0.1:1234", ""))
+	require.Equal(t, http.StatusOK, status(s, "[::1]:1234", ""))
+	require.Equal(t, http.StatusForbidden, status(s, "192.168.1.10:1234", ""))
+
+	// With admin token, it's required from any address
+	s = &Server{adminToken: "secret"}
+	require.Equal(t, http.StatusOK, status(s, "192.168.1.10:1234", "Bearer secret"))
+	require.Equal(t, http.StatusUnauthorized, status(s, "127.0.0.1:1234", ""))
+	require.Equal(t, http.StatusUnauthorized, status(s, "192.168.1.10:1234", "Bearer wrong"))
+}
@@ -0,0 +1,202 @@
+// Package relayer contains the client and messages used by identities that
+// don't have an Ethereum account to publish their state through a relayer.
+package relayer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	zktypes "github.com/iden3/go-circom-prover-verifier/types"
+	"github.com/iden3/go-iden3-core/components/httpclient"
+	"github.com/iden3/go-iden3-core/components/idenpuboffchain"
+	"github.com/iden3/go-iden3-core/components/idenpubonchain"
+	"github.com/iden3/go-iden3-core/core"
+	"github.com/iden3/go-iden3-core/merkletree"
+	"github.com/iden3/iden3-mobile/go/auth"
+)
+
+const (
+	// PathIdenPublicData is the path of the relayer under which the off chain
+	// public data of the identities is published and served:
+	//   POST <PathIdenPublicData>/<id>: publish the PublicDataBlobs of a state (ReqPublicData)
+	//   GET  <PathIdenPublicData>/<id>/laststate: last published PublicDataBlobs
+	//   GET  <PathIdenPublicData>/<id>/state/<state>: PublicDataBlobs of a state
+	// As the identity state commits to the trees roots, the relayer checks the
+	// consistency of the published data, and the signature of the identity so
+	// that nobody else can replace its last published data.
+	PathIdenPublicData = "idenpublicdata"
+	// PathIdStateArtifacts is the path of the relayer that serves the ZK
+	// artifacts of the identity state transition circuit.
+	PathIdStateArtifacts = "idstate/artifacts"
+	// PathIdenStateInit is the path of the relayer that sends the first state
+	// transition of an identity (from its genesis state) to the smart contract.
+	PathIdenStateInit = "idenstate/init"
+	// PathIdenStateSet is the path of the relayer that sends the following
+	// state transitions of an identity to the smart contract.
+	PathIdenStateSet = "idenstate/set"
+)
+
+// ReqInitState is the request of the first state transition of an identity.
+type ReqInitState struct {
+	ID           *core.ID         `json:"id" validate:"required"`
+	GenesisState *merkletree.Hash `json:"genesisState" validate:"required"`
+	NewState     *merkletree.Hash `json:"newState" validate:"required"`
+	Proof        *zktypes.Proof   `json:"proof" validate:"required"`
+	Signature    *auth.Signature  `json:"signature" validate:"required"`
+}
+
+// ReqSetState is the request of a state transition of an identity that
+// already has a state in the smart contract.
+type ReqSetState struct {
+	ID        *core.ID         `json:"id" validate:"required"`
+	NewState  *merkletree.Hash `json:"newState" validate:"required"`
+	Proof     *zktypes.Proof   `json:"proof" validate:"required"`
+	Signature *auth.Signature  `json:"signature" validate:"required"`
+}
+
+// ResState is the response of the relayer to a state transition request,
+// with the transaction sent to the smart contract.
+type ResState struct {
+	Tx *types.Transaction `json:"tx" validate:"required"`
+}
+
+// StateTransitionPayload returns the payload of a state transition request
+// that is signed. The zk proof already proves that the transition was done by
+// the identity; the signature allows the relayer to reject requests from
+// unauthenticated parties before spending gas.
+func StateTransitionPayload(newState *merkletree.Hash) []byte {
+	return newState[:]
+}
+
+// ReqPublicData is the request to publish the off chain public data of an
+// identity, signed by the identity.
+type ReqPublicData struct {
+	PublicData *idenpuboffchain.PublicDataBlobs `json:"publicData" validate:"required"`
+	Signature  *auth.Signature                  `json:"signature" validate:"required"`
+}
+
+// PublicDataPayload returns the payload of a public data publication that is
+// signed. The identity state commits to the rest of the public data.
+func PublicDataPayload(idenState *merkletree.Hash) []byte {
+	return idenState[:]
+}
+
+// NewPublicDataBlobs serializes the off chain public data of an identity.
+func NewPublicDataBlobs(publicData *idenpuboffchain.PublicData) (*idenpuboffchain.PublicDataBlobs, error) {
+	var rootsTree bytes.Buffer
+	if err := publicData.RootsTree.DumpTree(&rootsTree, publicData.RootsTreeRoot); err != nil {
+		return nil, err
+	}
+	var revocationsTree bytes.Buffer
+	if err := publicData.RevocationsTree.DumpTree(&revocationsTree, publicData.RevocationsTreeRoot); err != nil {
+		return nil, err
+	}
+	return &idenpuboffchain.PublicDataBlobs{
+		IdenState:           *publicData.IdenState,
+		ClaimsTreeRoot:      *publicData.ClaimsTreeRoot,
+		RevocationsTreeRoot: *publicData.RevocationsTreeRoot,
+		RevocationsTree:     revocationsTree.Bytes(),
+		RootsTreeRoot:       *publicData.RootsTreeRoot,
+		RootsTree:           rootsTree.Bytes(),
+	}, nil
+}
+
+// Signer signs a payload in a domain on behalf of the identity that sends the
+// state transitions.
+type Signer func(domain string, payload []byte) (*auth.Signature, error)
+
+// Client talks with the relayer API. It satisfies the IdenPubOnChainer
+// interface: the state transitions are sent through the relayer, and the rest
+// of methods are delegated to the IdenPubOnChainer used to read the smart
+// contract, so an identity without Ethereum account can publish its state.
+type Client struct {
+	idenpubonchain.IdenPubOnChainer
+	url        string
+	httpClient *httpclient.HttpClient
+	signer     Signer
+}
+
+// NewClient creates a client of the relayer at url.
+func NewClient(url string, idenPubOnChain idenpubonchain.IdenPubOnChainer, signer Signer) *Client {
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return &Client{
+		IdenPubOnChainer: idenPubOnChain,
+		url:              url,
+		httpClient:       httpclient.NewHttpClient(url),
+		signer:           signer,
+	}
+}
+
+// InitState sends the first state transition of the identity to the smart
+// contract through the relayer.
+func (c *Client) InitState(id *core.ID, genesisState *merkletree.Hash,
+	newState *merkletree.Hash, proof *zktypes.Proof) (*types.Transaction, error) {
+	sig, err := c.signer(auth.DomainStateTransition, StateTransitionPayload(newState))
+	if err != nil {
+		return nil, err
+	}
+	var res ResState
+	if err := c.httpClient.DoRequest(c.httpClient.NewRequest().Path(PathIdenStateInit).Post("").
+		BodyJSON(ReqInitState{
+			ID:           id,
+			GenesisState: genesisState,
+			NewState:     newState,
+			Proof:        proof,
+			Signature:    sig,
+		}), &res); err != nil {
+		return nil, err
+	}
+	return res.Tx, nil
+}
+
+// SetState sends a state transition of the identity to the smart contract
+// through the relayer.
+func (c *Client) SetState(id *core.ID, newState *merkletree.Hash, proof *zktypes.Proof) (*types.Transaction, error) {
+	sig, err := c.signer(auth.DomainStateTransition, StateTransitionPayload(newState))
+	if err != nil {
+		return nil, err
+	}
+	var res ResState
+	if err := c.httpClient.DoRequest(c.httpClient.NewRequest().Path(PathIdenStateSet).Post("").
+		BodyJSON(ReqSetState{
+			ID:        id,
+			NewState:  newState,
+			Proof:     proof,
+			Signature: sig,
+		}), &res); err != nil {
+		return nil, err
+	}
+	return res.Tx, nil
+}
+
+// Url returns the url where the relayer serves the published off chain public
+// data, so that Client satisfies the IdenPubOffChainWriter interface.
+func (c *Client) Url() string {
+	return c.url + PathIdenPublicData + "/"
+}
+
+// Publish sends the off chain public data of the identity to the relayer, so
+// that Client satisfies the IdenPubOffChainWriter interface.
+func (c *Client) Publish(id *core.ID, publicData *idenpuboffchain.PublicData) error {
+	blobs, err := NewPublicDataBlobs(publicData)
+	if err != nil {
+		return err
+	}
+	sig, err := c.signer(auth.DomainPublicData, PublicDataPayload(&blobs.IdenState))
+	if err != nil {
+		return err
+	}
+	return c.httpClient.DoRequest(c.httpClient.NewRequest().Path(
+		fmt.Sprintf("%s/%s", PathIdenPublicData, id.String())).Post("").
+		BodyJSON(ReqPublicData{PublicData: blobs, Signature: sig}), nil)
+}
+
+// ArtifactsUrl returns the url where the relayer serves the ZK artifacts of
+// the identity state transition circuit.
+func (c *Client) ArtifactsUrl() string {
+	return c.url + PathIdStateArtifacts
+}
@@ -0,0 +1,246 @@
+// Package testenv provides a local environment to write integration tests of
+// apps that use iden3mobile without any remote service: a local (in memory)
+// stand-in of the smart contract whose time and block number are driven by a
+// TimeBlock, the ZK artifacts read from a local directory, and the mockup
+// issuers, verifiers and relayer of the mockupserver package.
+//
+// By default the ZK artifacts are test circuits generated in the temporary
+// directory (see GenZkCircuit), so that the tests that generate and verify
+// ZK proofs run offline. To test with the real circuits, set the
+// IDEN3_TEST_ZK_DIR environment variable to a directory with their artifacts
+// stored in the idenstatezk-issuer and credentialzk directories.
+package testenv
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	zktypes "github.com/iden3/go-circom-prover-verifier/types"
+	idenpubonchainlocal "github.com/iden3/go-iden3-core/components/idenpubonchain/local"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/iden3/iden3-mobile/go/mockupserver"
+	log "github.com/sirupsen/logrus"
+)
+
+// EnvZkDir is the environment variable with the directory of the artifacts of
+// the real circuits. If it's not set, test circuits are used.
+const EnvZkDir = "IDEN3_TEST_ZK_DIR"
+
+// ZkCircuit describes the ZK artifacts of a circuit.
+type ZkCircuit struct {
+	// Dir is the directory of the artifacts inside the ZK artifacts directory
+	Dir string
+	// PublicInputs are the inputs of the circuit that are its public
+	// signals, in order
+	PublicInputs []string
+	Hashes       zkutils.ZkFilesHashes
+}
+
+// ZkCircuitIdenState is the circuit of the identity state transitions.
+var ZkCircuitIdenState = ZkCircuit{
+	Dir:          "idenstatezk-issuer",
+	PublicInputs: []string{"id", "oldIdState", "newIdState"},
+	Hashes: zkutils.ZkFilesHashes{
+		ProvingKey:      "37b6b3addd52faf9357f1496312e6a86af4f5c41c557cda9931468809d32c03c",
+		VerificationKey: "473952ff80aef85403005eb12d1e78a3f66b1cc11e7bd55d6bfe94e0b5577640",
+		WitnessCalcWASM: "8eafd9314c4d2664a23bf98a4f42cd0c29984960ae3544747ba5fbd60905c41f",
+	},
+}
+
+// ZkCircuitCredential is the circuit of the demo credentials proved with ZK.
+var ZkCircuitCredential = ZkCircuit{
+	Dir:          "credentialzk",
+	PublicInputs: []string{"isIdenState"},
+	Hashes: zkutils.ZkFilesHashes{
+		ProvingKey:      "bdefc89d07d1dfab75c43f09aedb9da876496c5c3967383337482e4c5ae4f7d3",
+		VerificationKey: "12a730890e85e33d8bf0f2e54db41dcff875c2dc49011d7e2a283185f47ac0de",
+		WitnessCalcWASM: "6b3c28c4842e04129674eb71dc84d76dd8b290c84987929d54d890b7b8bed211",
+	},
+}
+
+// ZkDir returns the directory of the ZK artifacts: the one in EnvZkDir, or
+// the one where the test circuits are generated.
+func ZkDir() string {
+	if dir := os.Getenv(EnvZkDir); dir != "" {
+		return dir
+	}
+	return path.Join(os.TempDir(), "iden3-test-circuits")
+}
+
+// GenZkCircuits generates in dir the test circuits of circuits, replacing
+// their hashes by the hashes of the generated artifacts.
+func GenZkCircuits(dir string, circuits ...*ZkCircuit) error {
+	for _, circuit := range circuits {
+		hashes, err := GenZkCircuit(path.Join(dir, circuit.Dir), circuit.PublicInputs)
+		if err != nil {
+			return err
+		}
+		circuit.Hashes = *hashes
+	}
+	return nil
+}
+
+// LoadZkFiles returns the ZK artifacts of circuit stored in dir, checking
+// their hashes.
+func LoadZkFiles(circuit *ZkCircuit, dir string) (*zkutils.ZkFiles, error) {
+	zkFiles := zkutils.NewZkFiles("", path.Join(dir, circuit.Dir),
+		zkutils.ProvingKeyFormatGoBin, circuit.Hashes, true)
+	for _, basename := range []string{
+		fmt.Sprintf("proving_key.%v", zkutils.ProvingKeyFormatGoBin),
+		"verification_key.json",
+		"circuit.wasm",
+	} {
+		filename := path.Join(zkFiles.Path, basename)
+		if _, err := os.Stat(filename); err != nil {
+			return nil, fmt.Errorf("Missing ZK artifact %v: %w", filename, err)
+		}
+	}
+	// The artifacts found in the directory are only checked
+	if err := zkFiles.DownloadAll(); err != nil {
+		return nil, err
+	}
+	return zkFiles, nil
+}
+
+// TimeBlock is a clock for the local smart contract where the time and the
+// block number are set by the tests.
+type TimeBlock struct {
+	timeNow  int64
+	blockNow uint64
+	rw       sync.RWMutex
+}
+
+func (tb *TimeBlock) SetTime(t int64) {
+	tb.rw.Lock()
+	defer tb.rw.Unlock()
+	tb.timeNow = t
+}
+
+func (tb *TimeBlock) SetBlock(n uint64) {
+	tb.rw.Lock()
+	defer tb.rw.Unlock()
+	tb.blockNow = n
+}
+
+func (tb *TimeBlock) AddTime(t int64) {
+	tb.rw.Lock()
+	defer tb.rw.Unlock()
+	tb.timeNow += t
+}
+
+func (tb *TimeBlock) AddBlock(n uint64) {
+	tb.rw.Lock()
+	defer tb.rw.Unlock()
+	tb.blockNow += n
+}
+
+func (tb *TimeBlock) Time() time.Time {
+	tb.rw.RLock()
+	defer tb.rw.RUnlock()
+	return time.Unix(tb.timeNow, 0)
+}
+
+func (tb *TimeBlock) Block() uint64 {
+	tb.rw.RLock()
+	defer tb.rw.RUnlock()
+	return tb.blockNow
+}
+
+// Env is a local environment for integration tests.
+type Env struct {
+	TimeBlock *TimeBlock
+	// IdenPubOnChain is the local smart contract, driven by TimeBlock
+	IdenPubOnChain *idenpubonchainlocal.IdenPubOnChain
+	// ZkCircuitIdenState and ZkCircuitCredential are the circuits of the ZK
+	// artifacts, with their hashes. The identities that generate ZK proofs
+	// in the environment must expect these hashes.
+	ZkCircuitIdenState  ZkCircuit
+	ZkCircuitCredential ZkCircuit
+	ZkFilesIdenState    *zkutils.ZkFiles
+	ZkFilesCredential   *zkutils.ZkFiles
+	// ZkErr is the reason why the ZK artifacts are not available, or nil if
+	// they are
+	ZkErr error
+}
+
+// New creates a new environment with the ZK artifacts from ZkDir, generating
+// the test circuits if EnvZkDir is not set. If the ZK artifacts are not
+// available, the local smart contract can't verify state transitions and
+// ZkErr tells why.
+func New() *Env {
+	env := &Env{
+		TimeBlock:           &TimeBlock{},
+		ZkCircuitIdenState:  ZkCircuitIdenState,
+		ZkCircuitCredential: ZkCircuitCredential,
+	}
+	dir := ZkDir()
+	if os.Getenv(EnvZkDir) == "" {
+		env.ZkErr = GenZkCircuits(dir, &env.ZkCircuitIdenState, &env.ZkCircuitCredential)
+	}
+	var vk *zktypes.Vk
+	if env.ZkErr == nil {
+		env.ZkFilesIdenState, env.ZkErr = LoadZkFiles(&env.ZkCircuitIdenState, dir)
+	}
+	if env.ZkErr == nil {
+		env.ZkFilesCredential, env.ZkErr = LoadZkFiles(&env.ZkCircuitCredential, dir)
+	}
+	if env.ZkErr == nil {
+		vk, env.ZkErr = env.ZkFilesIdenState.VerificationKey()
+	}
+	if env.ZkErr != nil {
+		log.WithError(env.ZkErr).Error("ZK artifacts not available, the tests that need them will fail")
+		env.ZkFilesIdenState, env.ZkFilesCredential = nil, nil
+	}
+	env.IdenPubOnChain = idenpubonchainlocal.New(
+		env.TimeBlock.Time,
+		env.TimeBlock.Block,
+		vk,
+	)
+	return env
+}
+
+// RequireZk fails the test if the ZK artifacts are not available.
+func (env *Env) RequireZk(t testing.TB) {
+	t.Helper()
+	if env.ZkErr != nil {
+		t.Fatalf("ZK artifacts not available: %v", env.ZkErr)
+	}
+}
+
+// StartSync mines a block in the local smart contract every period, adding
+// blocks and seconds to the TimeBlock. The returned function stops mining and
+// waits until the mining goroutine returns; it can be called more than once.
+func (env *Env) StartSync(period time.Duration, blocks uint64, seconds int64) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(done)
+		for {
+			env.TimeBlock.AddTime(seconds)
+			env.TimeBlock.AddBlock(blocks)
+			env.IdenPubOnChain.Sync()
+			select {
+			case <-stop:
+				return
+			case <-time.After(period):
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(stop) })
+		<-done
+	}
+}
+
+// Serve starts a mockup server that publishes in the local smart contract,
+// failing the test if the ZK artifacts are not available.
+func (env *Env) Serve(t *testing.T, cfg *mockupserver.Conf) *mockupserver.Server {
+	t.Helper()
+	env.RequireZk(t)
+	return mockupserver.Serve(t, cfg, env.IdenPubOnChain, env.ZkFilesIdenState, env.ZkFilesCredential)
+}
@@ -0,0 +1,319 @@
+package testenv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+
+	bn256 "github.com/ethereum/go-ethereum/crypto/bn256/cloudflare"
+	"github.com/iden3/go-circom-prover-verifier/parsers"
+	zktypes "github.com/iden3/go-circom-prover-verifier/types"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+)
+
+// The test circuits have the same interface as the real ones (the witness
+// calculator accepts the same inputs and the public signals are the same),
+// but they don't constrain anything: the witness is the public inputs and
+// every point of the keys is the point at infinity, so the proofs are
+// generated in a few milliseconds and verify for any public signals.
+
+const (
+	// frLen is the size of a field element in the witness calculator memory:
+	// two 32 bit words with its type and 32 bytes with the long value
+	frLen = 8 + 32
+	// wasmPPrime is the address of the prime in the witness calculator memory
+	wasmPPrime = 8
+	// wasmPWitness is the address of the witness in the witness calculator
+	// memory
+	wasmPWitness = 64
+	// wasmNoSignal is the offset of the signals of the inputs that are not
+	// public, which are discarded
+	wasmNoSignal = 0x10000000
+)
+
+// GenZkCircuit writes in dir the ZK artifacts (proving key in the go binary
+// format, verification key and witness calculator) of a test circuit whose
+// public signals are the inputs named publicInputs, and returns their hashes.
+func GenZkCircuit(dir string, publicInputs []string) (*zkutils.ZkFilesHashes, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	pk, err := parsers.PkToGoBin(zkTestPk(len(publicInputs)))
+	if err != nil {
+		return nil, err
+	}
+	vk, err := zkTestVk(len(publicInputs))
+	if err != nil {
+		return nil, err
+	}
+	wasm := zkTestWitnessCalcWASM(publicInputs)
+	var hashes zkutils.ZkFilesHashes
+	for _, file := range []struct {
+		basename string
+		data     []byte
+		hash     *string
+	}{
+		{fmt.Sprintf("proving_key.%v", zkutils.ProvingKeyFormatGoBin), pk, &hashes.ProvingKey},
+		{"verification_key.json", vk, &hashes.VerificationKey},
+		{"circuit.wasm", wasm, &hashes.WitnessCalcWASM},
+	} {
+		if err := writeFileAtomic(path.Join(dir, file.basename), file.data); err != nil {
+			return nil, err
+		}
+		h := sha256.Sum256(file.data)
+		*file.hash = hex.EncodeToString(h[:])
+	}
+	return &hashes, nil
+}
+
+// writeFileAtomic writes data in filename through a temporary file, so that
+// the test binaries that generate the same circuits at the same time never
+// read a file partially written.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(path.Dir(filename), path.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// zkTestPk returns the proving key of a test circuit with nPublic public
+// signals.
+func zkTestPk(nPublic int) *zktypes.Pk {
+	nVars := nPublic + 1
+	domainSize := 2
+	g1s := func(n int) []*bn256.G1 {
+		ps := make([]*bn256.G1, n)
+		for i := range ps {
+			ps[i] = new(bn256.G1).ScalarBaseMult(zero())
+		}
+		return ps
+	}
+	g2s := func(n int) []*bn256.G2 {
+		ps := make([]*bn256.G2, n)
+		for i := range ps {
+			ps[i] = new(bn256.G2).ScalarBaseMult(zero())
+		}
+		return ps
+	}
+	return &zktypes.Pk{
+		A:          g1s(nVars),
+		B2:         g2s(nVars),
+		B1:         g1s(nVars),
+		C:          g1s(nVars),
+		NVars:      nVars,
+		NPublic:    nPublic,
+		VkAlpha1:   g1s(1)[0],
+		VkDelta1:   g1s(1)[0],
+		VkBeta1:    g1s(1)[0],
+		VkBeta2:    g2s(1)[0],
+		VkDelta2:   g2s(1)[0],
+		HExps:      g1s(domainSize + 1),
+		DomainSize: domainSize,
+		PolsA:      make([]map[int]*big.Int, nVars),
+		PolsB:      make([]map[int]*big.Int, nVars),
+	}
+}
+
+// zkTestVk returns the verification key (in the json format of snarkjs) of a
+// test circuit with nPublic public signals.
+func zkTestVk(nPublic int) ([]byte, error) {
+	g1 := []string{"0", "1", "0"}
+	g2 := [][]string{{"0", "0"}, {"1", "0"}, {"0", "0"}}
+	ic := make([][]string, nPublic+1)
+	for i := range ic {
+		ic[i] = g1
+	}
+	return json.Marshal(parsers.VkString{
+		Alpha: g1,
+		Beta:  g2,
+		Gamma: g2,
+		Delta: g2,
+		IC:    ic,
+	})
+}
+
+// zkTestWitnessCalcWASM returns the witness calculator of a test circuit: a
+// WASM module with the interface of the circom witness calculators, where
+// the witness is the signal one followed by the inputs in publicInputs.
+func zkTestWitnessCalcWASM(publicInputs []string) []byte {
+	nVars := int32(len(publicInputs) + 1)
+
+	// getSignalOffset32(pR, component, hashMSB, hashLSB) stores in pR the
+	// offset of the input with the hash, which is the index in the witness
+	// of the public inputs.
+	var getSignalOffset32 []byte
+	for i, name := range publicInputs {
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		hash := h.Sum64()
+		getSignalOffset32 = append(getSignalOffset32, wasmLocalGet(2)...)
+		getSignalOffset32 = append(getSignalOffset32, wasmI32Const(int32(hash>>32))...)
+		getSignalOffset32 = append(getSignalOffset32, 0x46) // i32.eq
+		getSignalOffset32 = append(getSignalOffset32, wasmLocalGet(3)...)
+		getSignalOffset32 = append(getSignalOffset32, wasmI32Const(int32(hash))...)
+		getSignalOffset32 = append(getSignalOffset32, 0x46, 0x71) // i32.eq i32.and
+		getSignalOffset32 = append(getSignalOffset32, 0x04, 0x40) // if
+		getSignalOffset32 = append(getSignalOffset32, wasmLocalGet(0)...)
+		getSignalOffset32 = append(getSignalOffset32, wasmI32Const(int32(i+1))...)
+		getSignalOffset32 = append(getSignalOffset32, wasmI32Store(0)...)
+		getSignalOffset32 = append(getSignalOffset32, 0x0f, 0x0b) // return end
+	}
+	getSignalOffset32 = append(getSignalOffset32, wasmLocalGet(0)...)
+	getSignalOffset32 = append(getSignalOffset32, wasmI32Const(wasmNoSignal)...)
+	getSignalOffset32 = append(getSignalOffset32, wasmI32Store(0)...)
+
+	// setSignal(cIdx, component, signal, pVal) copies the field element at
+	// pVal to the signal in the witness, discarding the signals that are not
+	// public inputs.
+	setSignal := append(wasmLocalGet(2), wasmI32Const(nVars)...)
+	setSignal = append(setSignal, 0x49, 0x04, 0x40) // i32.lt_u if
+	for offset := uint32(0); offset < frLen; offset += 4 {
+		setSignal = append(setSignal, wasmLocalGet(2)...)
+		setSignal = append(setSignal, wasmI32Const(frLen)...)
+		setSignal = append(setSignal, 0x6c) // i32.mul
+		setSignal = append(setSignal, wasmI32Const(wasmPWitness)...)
+		setSignal = append(setSignal, 0x6a) // i32.add
+		setSignal = append(setSignal, wasmLocalGet(3)...)
+		setSignal = append(setSignal, wasmI32Load(offset)...)
+		setSignal = append(setSignal, wasmI32Store(offset)...)
+	}
+	setSignal = append(setSignal, 0x0b) // end
+
+	// init(sanityCheck) sets the signal one.
+	init := append(wasmI32Const(wasmPWitness), wasmI32Const(1)...)
+	init = append(init, wasmI32Store(0)...)
+
+	// getPWitness(i) returns the address of the signal i in the witness.
+	getPWitness := append(wasmLocalGet(0), wasmI32Const(frLen)...)
+	getPWitness = append(getPWitness, 0x6c) // i32.mul
+	getPWitness = append(getPWitness, wasmI32Const(wasmPWitness)...)
+	getPWitness = append(getPWitness, 0x6a) // i32.add
+
+	const (
+		typeRetI32 = iota
+		typeI32
+		typeI32x4
+		typeI32RetI32
+	)
+	types := [][]byte{
+		{0x60, 0x00, 0x01, 0x7f},
+		{0x60, 0x01, 0x7f, 0x00},
+		{0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00},
+		{0x60, 0x01, 0x7f, 0x01, 0x7f},
+	}
+	funcs := []struct {
+		name string
+		typ  byte
+		code []byte
+	}{
+		{"getFrLen", typeRetI32, wasmI32Const(frLen)},
+		{"getPRawPrime", typeRetI32, wasmI32Const(wasmPPrime)},
+		{"getNVars", typeRetI32, wasmI32Const(nVars)},
+		{"init", typeI32, init},
+		{"getSignalOffset32", typeI32x4, getSignalOffset32},
+		{"setSignal", typeI32x4, setSignal},
+		{"getPWitness", typeI32RetI32, getPWitness},
+		{"getWitnessBuffer", typeRetI32, wasmI32Const(wasmPWitness)},
+	}
+
+	var funcSec, exportSec, codeSec [][]byte
+	for i, f := range funcs {
+		funcSec = append(funcSec, []byte{f.typ})
+		exportSec = append(exportSec, append(wasmName(f.name), 0x00, byte(i)))
+		body := append([]byte{0x00}, f.code...) // no locals
+		body = append(body, 0x0b)               // end
+		codeSec = append(codeSec, append(wasmU32(uint32(len(body))), body...))
+	}
+	exportSec = append(exportSec, append(wasmName("memory"), 0x02, 0x00))
+
+	// The memory starts with the pointer to the free memory, followed by
+	// the prime and the witness.
+	pFree := wasmPWitness + uint32(nVars)*frLen
+	var data []byte
+	data = append(data, wasmI32Const(0)...)
+	data = append(data, 0x0b) // end
+	mem := make([]byte, wasmPPrime+32)
+	binary.LittleEndian.PutUint32(mem, pFree)
+	prime := zktypes.R.Bytes()
+	for i, b := range prime {
+		mem[wasmPPrime+len(prime)-1-i] = b
+	}
+	data = append(data, wasmU32(uint32(len(mem)))...)
+	data = append(data, mem...)
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, wasmSection(1, types)...)
+	module = append(module, wasmSection(3, funcSec)...)
+	module = append(module, wasmSection(5, [][]byte{{0x00, 0x01}})...) // one page
+	module = append(module, wasmSection(7, exportSec)...)
+	module = append(module, wasmSection(10, codeSec)...)
+	module = append(module, wasmSection(11, [][]byte{append([]byte{0x00}, data...)})...)
+	return module
+}
+
+func zero() *big.Int { return big.NewInt(0) }
+
+func wasmU32(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmS32(v int32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmName(name string) []byte {
+	return append(wasmU32(uint32(len(name))), name...)
+}
+
+func wasmSection(id byte, entries [][]byte) []byte {
+	content := wasmU32(uint32(len(entries)))
+	for _, entry := range entries {
+		content = append(content, entry...)
+	}
+	return append(append([]byte{id}, wasmU32(uint32(len(content)))...), content...)
+}
+
+func wasmLocalGet(i byte) []byte { return []byte{0x20, i} }
+
+func wasmI32Const(v int32) []byte { return append([]byte{0x41}, wasmS32(v)...) }
+
+func wasmI32Load(offset uint32) []byte {
+	return append([]byte{0x28, 0x02}, wasmU32(offset)...)
+}
+
+func wasmI32Store(offset uint32) []byte {
+	return append([]byte{0x36, 0x02}, wasmU32(offset)...)
+}
@@ -0,0 +1,47 @@
+package testenv
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/iden3/go-circom-prover-verifier/prover"
+	"github.com/iden3/go-circom-prover-verifier/verifier"
+	witnesscalc "github.com/iden3/go-circom-witnesscalc"
+	zkutils "github.com/iden3/go-iden3-core/utils/zk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenZkCircuit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testGenZkCircuit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	hashes, err := GenZkCircuit(dir, []string{"a", "b"})
+	require.Nil(t, err)
+	zkFiles := zkutils.NewZkFiles("", dir, zkutils.ProvingKeyFormatGoBin, *hashes, false)
+	// The generated artifacts are found with their hashes
+	require.Nil(t, zkFiles.DownloadAll())
+	require.Nil(t, zkFiles.LoadAll())
+
+	wasm, err := zkFiles.WitnessCalcWASM()
+	require.Nil(t, err)
+	b, _ := new(big.Int).SetString("21888242871839275222246405745257275088548364400416034343698204186575808495616", 10)
+	w, err := witnesscalc.CalculateWitnessBinWASM(wasm, map[string]interface{}{
+		"b":       b,
+		"private": []*big.Int{big.NewInt(3), big.NewInt(4)},
+		"a":       big.NewInt(2),
+	})
+	require.Nil(t, err)
+	require.Equal(t, []*big.Int{big.NewInt(1), big.NewInt(2), b}, w)
+
+	pk, err := zkFiles.ProvingKey()
+	require.Nil(t, err)
+	proof, pubSignals, err := prover.GenerateProof(pk, w)
+	require.Nil(t, err)
+	require.Equal(t, []*big.Int{big.NewInt(2), b}, pubSignals)
+	vk, err := zkFiles.VerificationKey()
+	require.Nil(t, err)
+	require.True(t, verifier.Verify(vk, proof, pubSignals))
+}